@@ -46,3 +46,10 @@ Selector labels
 app.kubernetes.io/name: {{ include "certificator.name" . }}
 app.kubernetes.io/instance: {{ .Release.Name }}
 {{- end }}
+
+{{/*
+Leader election is required when running more than one replica
+*/}}
+{{- define "certificator.leaderElection" -}}
+{{- or .Values.leaderElection.enabled (gt (int .Values.replicas) 1) }}
+{{- end }}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
@@ -13,14 +15,28 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/nais/certificator/pkg/bundleserver"
 	"github.com/nais/certificator/pkg/certbundle"
 	"github.com/nais/certificator/pkg/config"
+	"github.com/nais/certificator/pkg/fswatch"
+	"github.com/nais/certificator/pkg/health"
 	"github.com/nais/certificator/pkg/kube"
 	"github.com/nais/certificator/pkg/loader"
 	"github.com/nais/certificator/pkg/metrics"
+	"github.com/nais/certificator/pkg/status"
 	"github.com/nais/certificator/pkg/version"
 )
 
+// The main loop reports that it is alive at this interval. If it fails to do so for longer than
+// the apply timeout plus the heartbeat timeout, the liveness probe fails.
+const (
+	heartbeatInterval = 10 * time.Second
+	heartbeatTimeout  = time.Minute
+)
+
+// Changes to watched files are acted upon once no further changes have been seen for this long.
+const fileChangeDebounce = 2 * time.Second
+
 func main() {
 	err := run()
 	if err != nil {
@@ -29,19 +45,77 @@ func main() {
 	}
 }
 
-func update(ctx context.Context, cfg *config.Config) (*certbundle.Bundle, error) {
-	bundle := certbundle.New(cfg.JksPassword)
-	err := loader.BundleFromPaths(cfg.CADirectories, bundle)
+func update(ctx context.Context, cfg *config.Config, downloader *loader.Downloader) (*certbundle.Bundle, error) {
+	bundle := certbundle.New(
+		cfg.JksPassword,
+		certbundle.WithValidityPolicy(cfg.ValidityPolicy),
+		certbundle.WithCAPolicy(cfg.CAPolicy),
+	)
+	err := downloader.BundleFromSources(ctx, bundle, cfg.Sources)
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithTimeout(ctx, cfg.DownloadTimeout)
-	defer cancel()
-	err = loader.BundleFromURLs(ctx, bundle, cfg.CAUrls)
-	if err != nil {
-		return nil, err
+	return bundle, nil
+}
+
+func certificateMetrics(bundle *certbundle.Bundle) []metrics.Certificate {
+	certs := bundle.Certificates()
+	result := make([]metrics.Certificate, len(certs))
+	for i, cert := range certs {
+		result[i] = metrics.Certificate{
+			Subject:     cert.Subject.String(),
+			Fingerprint: certbundle.Fingerprint(cert),
+			Sources:     bundle.Sources(cert),
+			NotAfter:    cert.NotAfter,
+		}
+	}
+	return result
+}
+
+func statusReport(sources []loader.Source, bundle *certbundle.Bundle, namespaces kube.Namespaces) status.Report {
+	report := status.Report{
+		Sources:    make([]status.Source, len(sources)),
+		Namespaces: make([]status.Namespace, 0, len(namespaces)),
 	}
-	return bundle, err
+	for i, source := range sources {
+		report.Sources[i] = status.Source{
+			Source: source.String(),
+			Type:   string(source.Type),
+			Tags:   source.Tags,
+		}
+	}
+	var changedAt time.Time
+	if bundle != nil {
+		changedAt = bundle.ChangedAt()
+		report.Bundle = &status.Bundle{
+			Hash:         hex.EncodeToString(bundle.Hash()),
+			ChangedAt:    changedAt,
+			Certificates: make([]status.Certificate, 0, bundle.Len()),
+		}
+		for _, cert := range bundle.Certificates() {
+			report.Bundle.Certificates = append(report.Bundle.Certificates, status.Certificate{
+				Subject:     cert.Subject.String(),
+				Issuer:      cert.Issuer.String(),
+				Fingerprint: certbundle.Fingerprint(cert),
+				NotAfter:    cert.NotAfter,
+				Sources:     bundle.Sources(cert),
+			})
+		}
+	}
+	for _, namespace := range namespaces {
+		report.Namespaces = append(report.Namespaces, status.Namespace{
+			Name:        namespace.Name,
+			UpToDate:    bundle != nil && !namespace.LastSuccess.Before(changedAt),
+			LastSeen:    namespace.LastSeen,
+			LastSuccess: namespace.LastSuccess,
+			LastFailure: namespace.LastFailure,
+			LastError:   namespace.LastError,
+		})
+	}
+	slices.SortFunc(report.Namespaces, func(a, b status.Namespace) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return report
 }
 
 func run() error {
@@ -67,13 +141,24 @@ func run() error {
 
 	buildTime, _ := version.BuildTime()
 	log.Infof("Certificator %s built on %s", version.Version(), buildTime)
-	log.Infof("Configured %d CA certificate sources", len(cfg.CAUrls)+len(cfg.CADirectories))
-
-	for _, src := range cfg.CADirectories {
-		log.Infof("File system source: %v", src)
+	if cfg.ConfigFile != "" {
+		log.Infof("Read configuration file %s", cfg.ConfigFile)
 	}
-	for _, src := range cfg.CAUrls {
-		log.Infof("Remote URL source: %v", src)
+	log.Infof("Configured %d CA certificate sources", len(cfg.Sources))
+
+	for _, src := range cfg.Sources {
+		logger := log.NewEntry(log.StandardLogger())
+		if len(src.Tags) > 0 {
+			logger = logger.WithField("tags", src.Tags)
+		}
+		switch src.Type {
+		case loader.SourceDirectory:
+			logger.Infof("File system source: %v", src)
+		case loader.SourceKubernetes:
+			logger.Infof("Kubernetes source: %v", src)
+		default:
+			logger.Infof("Remote URL source: %v", src)
+		}
 	}
 
 	clientset, err := kube.Client()
@@ -81,24 +166,29 @@ func run() error {
 		return fmt.Errorf("init kubernetes client: %w", err)
 	}
 
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT)
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	updatedBundle, err = update(ctx, cfg)
-	if err != nil {
-		return fmt.Errorf("failed to retrieve certificate when starting: %w", err)
-	}
-
-	log.Infof("Refreshed certificate list from external sources with %d entries", updatedBundle.Len())
-	bundle = updatedBundle
-
-	log.Infof("Configuration complete, starting application.")
+	healthStatus := health.New(cfg.HealthStalenessThreshold, cfg.ApplyTimeout+heartbeatTimeout)
+	statusRequests := make(chan chan<- status.Report)
+	bundleServer := bundleserver.New()
+	downloader := loader.NewDownloader(
+		loader.WithMaxStaleness(cfg.DownloadMaxStaleness),
+		loader.WithCacheDirectory(cfg.DownloadCacheDirectory),
+		loader.WithKubernetesClient(clientset),
+	)
 
 	go func() {
 		log.Infof("Starting metrics server at %s", cfg.MetricsAddress)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		mux.Handle("/healthz", healthStatus.LivenessHandler())
+		mux.Handle("/readyz", healthStatus.ReadinessHandler())
+		mux.Handle("/status", status.Handler(statusRequests))
+		mux.Handle("/bundle/", bundleServer)
 		srv := &http.Server{
 			Addr:              cfg.MetricsAddress,
-			Handler:           promhttp.Handler(),
+			Handler:           mux,
 			ReadHeaderTimeout: 10 * time.Second,
 		}
 		if srvErr := srv.ListenAndServe(); srvErr != nil {
@@ -107,26 +197,145 @@ func run() error {
 		}
 	}()
 
+	updatedBundle, err = update(ctx, cfg, downloader)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve certificate when starting: %w", err)
+	}
+	healthStatus.Refreshed()
+
+	log.Infof("Refreshed certificate list from external sources with %d entries (%d duplicates skipped)", updatedBundle.Len(), updatedBundle.Duplicates())
+	bundle = updatedBundle
+	metrics.SetCertificates(certificateMetrics(bundle))
+	err = bundleServer.Set(bundle)
+	if err != nil {
+		return fmt.Errorf("serve certificate bundle: %w", err)
+	}
+
+	log.Infof("Configuration complete, starting application.")
+
 	downloadTimer := time.NewTimer(1 * time.Millisecond)
 	bundleTimer := time.NewTimer(time.Hour)
 	bundleTimer.Stop()
 
-	setupNamespaceWatch := func() {
-		namespaceWatcher = make(chan *kube.Namespace, 1024)
+	namespaceWatcher = make(chan *kube.Namespace, 1024)
+	go func() {
+		log.Infof("Starting Kubernetes namespace watcher.")
+		healthStatus.SetWatching(true)
+		defer healthStatus.SetWatching(false)
+		watchErr := kube.Watch(ctx, clientset, cfg.NamespaceLabelSelector, cfg.ResyncInterval, namespaceWatcher)
+		if watchErr != nil {
+			log.Errorf("Init Kubernetes namespace watcher: %s", watchErr)
+		} else {
+			log.Infof("Kubernetes namespace watcher stopped.")
+		}
+	}()
+
+	var configChanges chan struct{}
+	if cfg.ConfigFile != "" {
+		configChanges = make(chan struct{}, 1)
 		go func() {
-			log.Infof("Starting Kubernetes namespace watcher.")
-			watchErr := kube.Watch(ctx, clientset, cfg.NamespaceLabelSelector, namespaceWatcher)
+			log.Infof("Watching configuration file %s for changes.", cfg.ConfigFile)
+			watchErr := fswatch.Watch(ctx, []string{cfg.ConfigFile}, fileChangeDebounce, configChanges)
 			if watchErr != nil {
-				log.Errorf("Init Kubernetes namespace watcher: %s", watchErr)
-			} else {
-				log.Errorf("Kubernetes namespace watcher stopped.")
+				log.Errorf("Watch configuration file: %s", watchErr)
 			}
 		}()
 	}
 
-	setupNamespaceWatch()
+	// Rebuild the bundle as soon as files in directory sources or Kubernetes sources change, instead of waiting for the download timer.
+	// The watchers are restarted whenever the configuration is reloaded, as the set of sources may have changed.
+	var directoryChanges, kubernetesChanges chan struct{}
+	cancelSourceWatch := func() {}
+	defer func() { cancelSourceWatch() }() //nolint:gocritic // cancelSourceWatch is reassigned when reloading
+	watchSources := func() {
+		cancelSourceWatch()
+		directoryChanges = nil
+		kubernetesChanges = nil
+		directories := make([]string, 0)
+		trees := make([]string, 0)
+		kubernetesSources := make([]loader.Source, 0)
+		for _, source := range cfg.Sources {
+			switch {
+			case source.Type == loader.SourceDirectory && source.Directory.Recursive:
+				trees = append(trees, source.Path)
+			case source.Type == loader.SourceDirectory:
+				directories = append(directories, source.Path)
+			case source.Type == loader.SourceKubernetes:
+				kubernetesSources = append(kubernetesSources, source)
+			}
+		}
+		var watchCtx context.Context
+		watchCtx, cancelSourceWatch = context.WithCancel(ctx)
+		if len(directories)+len(trees) > 0 {
+			changes := make(chan struct{}, 1)
+			directoryChanges = changes
+			go func() {
+				log.Infof("Watching %d CA certificate directories for changes.", len(directories)+len(trees))
+				watchErr := fswatch.WatchTrees(watchCtx, directories, trees, fileChangeDebounce, changes)
+				if watchErr != nil {
+					log.Errorf("Watch CA certificate directories: %s", watchErr)
+				}
+			}()
+		}
+		if len(kubernetesSources) > 0 {
+			changes := make(chan struct{}, 1)
+			kubernetesChanges = changes
+			go func() {
+				log.Infof("Watching %d Kubernetes CA certificate sources for changes.", len(kubernetesSources))
+				watchErr := loader.WatchKubernetes(watchCtx, clientset, kubernetesSources, changes)
+				if watchErr != nil {
+					log.Errorf("Watch Kubernetes CA certificate sources: %s", watchErr)
+				}
+			}()
+		}
+	}
+	watchSources()
+
 	applies = make(chan func() error, 1024)
 
+	drifts := make(chan string, 1024)
+	resourceCache := kube.NewCache(clientset, cfg.ResourceKinds, cfg.ResyncInterval)
+	err = resourceCache.WatchDrift(ctx, drifts)
+	if err != nil {
+		return fmt.Errorf("watch CA certificate bundle resources: %w", err)
+	}
+	resourceCache.Start(ctx)
+	log.Infof("Waiting for cache of existing CA certificate bundle resources to sync")
+	if !resourceCache.WaitForSync(ctx) {
+		return nil
+	}
+
+	// Only the leader writes CA bundles into namespaces. Followers keep their bundle and caches up to date,
+	// so that they can take over right away.
+	leader := !cfg.LeaderElection
+	var leaderChanges chan bool
+	if cfg.LeaderElection {
+		leaderChanges = make(chan bool, 1)
+		identity, hostnameErr := os.Hostname()
+		if hostnameErr != nil {
+			return fmt.Errorf("determine leader election identity: %w", hostnameErr)
+		}
+		go func() {
+			log.Infof("Starting leader election for lease %s/%s", cfg.LeaderElectionNamespace, cfg.LeaderElectionLeaseName)
+			electErr := kube.Elect(ctx, clientset, kube.LeaderElection{
+				LeaseName:      cfg.LeaderElectionLeaseName,
+				LeaseNamespace: cfg.LeaderElectionNamespace,
+				Identity:       identity,
+				LeaseDuration:  cfg.LeaderElectionLeaseDuration,
+				RenewDeadline:  cfg.LeaderElectionRenewDeadline,
+				RetryPeriod:    cfg.LeaderElectionRetryPeriod,
+			}, leaderChanges)
+			if electErr != nil {
+				log.Errorf("Leader election: %s", electErr)
+				cancel()
+			}
+		}()
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	healthStatus.Heartbeat()
+
 	for ctx.Err() == nil {
 		select {
 		case <-ctx.Done():
@@ -137,7 +346,8 @@ func run() error {
 			// Each time a namespace is returned from the watcher, add it to the list of candidates,
 			// and trigger a synchronization.
 			if !ok {
-				setupNamespaceWatch()
+				// The watcher only stops when shutting down.
+				namespaceWatcher = nil
 				continue
 			}
 			if strings.HasPrefix(watchedNamespace.Name, "pg-") {
@@ -160,10 +370,79 @@ func run() error {
 			metrics.SetTotalNamespaces(len(namespaces))
 			bundleTimer.Reset(time.Millisecond)
 
+		case <-heartbeat.C:
+			healthStatus.Heartbeat()
+
+		case _, ok := <-configChanges:
+			if !ok {
+				configChanges = nil
+				continue
+			}
+			// Only sources, with their policies, take effect without a restart.
+			ignored, reloadErr := cfg.Reload()
+			if reloadErr != nil {
+				metrics.IncConfigReload(1)
+				log.Errorf("Rejected changed configuration, keeping the previous one: %s", reloadErr)
+				continue
+			}
+			metrics.IncConfigReload(0)
+			log.Infof("Configuration file changed; reloaded %d CA certificate sources.", len(cfg.Sources))
+			if len(ignored) > 0 {
+				log.Warnf("Changes to %s in the configuration file take effect after a restart.", strings.Join(ignored, ", "))
+			}
+			watchSources()
+			downloadTimer.Reset(time.Millisecond)
+
+		case _, ok := <-directoryChanges:
+			if !ok {
+				directoryChanges = nil
+				continue
+			}
+			log.Infof("Files in CA certificate directories changed; refreshing certificate list.")
+			downloadTimer.Reset(time.Millisecond)
+
+		case _, ok := <-kubernetesChanges:
+			if !ok {
+				kubernetesChanges = nil
+				continue
+			}
+			log.Infof("Kubernetes CA certificate sources changed; refreshing certificate list.")
+			downloadTimer.Reset(time.Millisecond)
+
+		case reply := <-statusRequests:
+			reply <- statusReport(cfg.Sources, bundle, namespaces)
+
+		case leader = <-leaderChanges:
+			if !leader {
+				log.Infof("No longer the leader; stopped writing CA certificate bundles.")
+				applyCancel()
+				bundleTimer.Stop()
+				continue
+			}
+			// The previous leader may or may not have written the current bundle everywhere.
+			// Resources that are already up to date are skipped when applying.
+			log.Infof("Became the leader; writing CA certificate bundles to all namespaces.")
+			for _, namespace := range namespaces {
+				namespace.LastSuccess = time.Time{}
+			}
+			bundleTimer.Reset(time.Millisecond)
+
+		case name := <-drifts:
+			// A resource was deleted or changed by someone else; re-queue its namespace.
+			namespace, ok := namespaces[name]
+			if !ok {
+				continue
+			}
+			log.Infof("CA certificate bundle in namespace %q was deleted or changed; re-applying.", name)
+			namespace.LastSuccess = time.Time{}
+			metrics.IncDriftRepairs()
+			metrics.SetPendingNamespaces(len(namespaces.UnsuccessfulSince(bundle.ChangedAt())))
+			bundleTimer.Reset(time.Millisecond)
+
 		case <-bundleTimer.C:
 			// Run the configmap synchronization for all namespaces that haven't been updated
 			// since the last bundle update.
-			if bundle == nil {
+			if bundle == nil || !leader {
 				continue
 			}
 			candidates := namespaces.UnsuccessfulSince(bundle.ChangedAt())
@@ -174,14 +453,22 @@ func run() error {
 				continue
 			}
 			applyContext, applyCancel = context.WithTimeout(ctx, cfg.ApplyTimeout) //nolint:govet // applyCancel is called via deferred closure
-			log.Infof("Generating %d CA certificate bundle ConfigMap operations, timeout %s", len(candidates), cfg.ApplyTimeout)
-			err = kube.GenerateApplyOperations(applyContext, clientset, bundle, candidates, applies)
+			log.Infof("Generating %d CA certificate bundle %v operations, timeout %s", len(candidates), cfg.ResourceKinds, cfg.ApplyTimeout)
+			err = kube.GenerateApplyOperations(applyContext, clientset, bundle, kube.Options{
+				Kinds:          cfg.ResourceKinds,
+				ForceConflicts: cfg.ApplyForceConflicts,
+				Cache:          resourceCache,
+			}, candidates, applies)
 			if err != nil {
 				log.Errorf("Failed to generate CA certificate bundles: %s", err)
 				applyCancel()
 			}
 
 		case apply := <-applies:
+			if !leader {
+				// Discard operations generated before leadership was lost.
+				continue
+			}
 			err = apply()
 			pending := len(namespaces.UnsuccessfulSince(bundle.ChangedAt()))
 			metrics.SetPendingNamespaces(pending)
@@ -203,10 +490,11 @@ func run() error {
 
 		case <-downloadTimer.C:
 			// Refresh the certificate bundle.
-			updatedBundle, err = update(ctx, cfg)
+			updatedBundle, err = update(ctx, cfg, downloader)
 			if err == nil {
+				healthStatus.Refreshed()
 				metrics.IncRefresh(0)
-				log.Infof("Refreshed certificate list from external sources with %d entries", updatedBundle.Len())
+				log.Infof("Refreshed certificate list from external sources with %d entries (%d duplicates skipped)", updatedBundle.Len(), updatedBundle.Duplicates())
 				downloadTimer.Reset(cfg.DownloadInterval)
 				log.Debugf("Next refresh in %s", cfg.DownloadInterval)
 				if bundle != nil && bundle.Equal(updatedBundle) {
@@ -214,7 +502,11 @@ func run() error {
 					continue
 				}
 				bundle = updatedBundle
-				metrics.SetCertificates(bundle.Len())
+				metrics.SetCertificates(certificateMetrics(bundle))
+				err = bundleServer.Set(bundle)
+				if err != nil {
+					log.Errorf("Serve certificate bundle: %s", err)
+				}
 				bundleTimer.Reset(time.Millisecond)
 			} else {
 				metrics.IncRefresh(1)
@@ -0,0 +1,103 @@
+package bundleserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/nais/certificator/pkg/kube"
+)
+
+// Bundle is the certificate bundle being served.
+type Bundle interface {
+	kube.BundleWriter
+	ChangedAt() time.Time
+}
+
+// Formats the bundle is served in, by filename.
+var formats = []struct {
+	filename    string
+	contentType string
+	write       func(Bundle, io.Writer) error
+}{
+	{"ca-bundle.pem", "application/x-pem-file", Bundle.WritePEM},
+	{"ca-bundle.jks", "application/x-java-keystore", Bundle.WriteJKS},
+	{"ca-bundle.p12", "application/x-pkcs12", Bundle.WritePKCS12},
+}
+
+type file struct {
+	contentType string
+	etag        string
+	data        []byte
+}
+
+type rendered struct {
+	changedAt time.Time
+	files     map[string]file
+}
+
+// Server serves the current certificate bundle in every supported format, e.g. /ca-bundle.pem.
+// Responses carry an ETag derived from the served content, so that clients can use conditional requests.
+type Server struct {
+	current atomic.Pointer[rendered]
+}
+
+func New() *Server {
+	return &Server{}
+}
+
+// Set the bundle to serve. Every format is rendered up front, so that each request
+// for the same bundle returns exactly the same content.
+func (server *Server) Set(bundle Bundle) error {
+	r := &rendered{
+		changedAt: bundle.ChangedAt(),
+		files:     make(map[string]file, len(formats)),
+	}
+	for _, format := range formats {
+		buf := &bytes.Buffer{}
+		err := format.write(bundle, buf)
+		if err != nil {
+			return fmt.Errorf("render %s: %w", format.filename, err)
+		}
+		// Keystores are not rendered identically twice, so the tag must be derived from the content itself.
+		sum := sha256.Sum256(buf.Bytes())
+		r.files[format.filename] = file{
+			contentType: format.contentType,
+			etag:        `"` + hex.EncodeToString(sum[:]) + `"`,
+			data:        buf.Bytes(),
+		}
+	}
+	server.current.Store(r)
+	return nil
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	current := server.current.Load()
+	if current == nil {
+		http.Error(w, "certificate bundle not loaded yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	filename := path.Base(r.URL.Path)
+	f, ok := current.files[filename]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", f.contentType)
+	w.Header().Set("ETag", f.etag)
+	http.ServeContent(w, r, filename, current.changedAt, bytes.NewReader(f.data))
+}
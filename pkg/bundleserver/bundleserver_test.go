@@ -0,0 +1,96 @@
+package bundleserver_test
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/nais/certificator/pkg/bundleserver"
+	"github.com/nais/certificator/pkg/certbundle"
+	"github.com/stretchr/testify/assert"
+)
+
+func bundleFromTestData(jksPassword string) *certbundle.Bundle {
+	f, err := os.Open("../../testdata/cacert.pem")
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil {
+			panic(closeErr)
+		}
+	}()
+
+	bundle := certbundle.New(jksPassword)
+	err = bundle.ReadAll(f)
+	if err != nil {
+		panic(err)
+	}
+	return bundle
+}
+
+func get(server http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, path, http.NoBody)
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestServer(t *testing.T) {
+	server := bundleserver.New()
+
+	response := get(server, "/bundle/ca-bundle.pem", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+
+	err := server.Set(bundleFromTestData("changeme"))
+	assert.NoError(t, err)
+
+	for path, contentType := range map[string]string{
+		"/bundle/ca-bundle.pem": "application/x-pem-file",
+		"/bundle/ca-bundle.jks": "application/x-java-keystore",
+		"/bundle/ca-bundle.p12": "application/x-pkcs12",
+	} {
+		response = get(server, path, nil)
+		assert.Equal(t, http.StatusOK, response.Code, path)
+		assert.Equal(t, contentType, response.Header().Get("Content-Type"), path)
+		assert.NotEmpty(t, response.Body.Bytes(), path)
+
+		etag := response.Header().Get("ETag")
+		assert.NotEmpty(t, etag, path)
+
+		response = get(server, path, http.Header{"If-None-Match": {etag}})
+		assert.Equal(t, http.StatusNotModified, response.Code, path)
+		assert.Empty(t, response.Body.Bytes(), path)
+	}
+
+	response = get(server, "/bundle/ca-bundle.txt", nil)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func TestServerETagPerFormat(t *testing.T) {
+	server := bundleserver.New()
+	err := server.Set(bundleFromTestData("changeme"))
+	assert.NoError(t, err)
+
+	etags := make(map[string]string)
+	for _, path := range []string{"/bundle/ca-bundle.pem", "/bundle/ca-bundle.jks", "/bundle/ca-bundle.p12"} {
+		etags[path] = get(server, path, nil).Header().Get("ETag")
+	}
+	assert.Len(t, slices.Compact(slices.Sorted(maps.Values(etags))), len(etags), "every format must have its own ETag")
+
+	err = server.Set(bundleFromTestData("changed"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, etags["/bundle/ca-bundle.pem"], get(server, "/bundle/ca-bundle.pem", nil).Header().Get("ETag"))
+	for _, path := range []string{"/bundle/ca-bundle.jks", "/bundle/ca-bundle.p12"} {
+		response := get(server, path, http.Header{"If-None-Match": {etags[path]}})
+		assert.Equal(t, http.StatusOK, response.Code, "%s must be served again when the keystore password changes", path)
+		assert.NotEqual(t, etags[path], response.Header().Get("ETag"), path)
+	}
+}
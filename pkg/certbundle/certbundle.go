@@ -2,32 +2,54 @@ package certbundle
 
 import (
 	"bytes"
+	"crypto/pbkdf2"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/pavlo-v-chernykh/keystore-go"
 	log "github.com/sirupsen/logrus"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 type Bundle struct {
-	certs     []*x509.Certificate
-	changedAt time.Time
-	password  string
+	certs      []*x509.Certificate
+	sources    map[string][]string
+	duplicates int
+	changedAt  time.Time
+	password   string
+	options    options
 }
 
-func New(password string) *Bundle {
-	return &Bundle{
+func New(password string, opts ...Option) *Bundle {
+	bundle := &Bundle{
 		certs:    make([]*x509.Certificate, 0),
+		sources:  make(map[string][]string),
 		password: password,
+		options:  defaultOptions(),
 	}
+	for _, opt := range opts {
+		opt(&bundle.options)
+	}
+	return bundle
+}
+
+// Fingerprint returns the hex encoded SHA-256 digest of the certificate's DER encoding.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
 }
 
+// ErrUnparseable is returned when reading data that isn't PEM or DER encoded certificates.
+var ErrUnparseable = errors.New("unparseable certificate data")
+
 func decode(data []byte) ([]byte, *x509.Certificate, error) {
 	block, rest := pem.Decode(data)
 	if block != nil {
@@ -45,6 +67,19 @@ func decode(data []byte) ([]byte, *x509.Certificate, error) {
 
 // Read PEM blocks or DER certificate from a reader until there are none left. Consumes all the data from the reader.
 func (bundle *Bundle) ReadAll(r io.Reader) error {
+	return bundle.ReadAllFrom("", r)
+}
+
+// ReadAllFrom works like ReadAll, and records the source the certificates were read from.
+// Certificates already present in the bundle are not added again, but the source is recorded for them as well.
+// Certificates are checked against the bundle's policies before any of them are added.
+// Options given here override the bundle's options for this source only.
+func (bundle *Bundle) ReadAllFrom(source string, r io.Reader, opts ...Option) error {
+	options := bundle.options
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	buf := &bytes.Buffer{}
 	_, err := io.Copy(buf, r)
 	if err != nil {
@@ -57,26 +92,53 @@ func (bundle *Bundle) ReadAll(r io.Reader) error {
 		var cert *x509.Certificate
 		data, cert, err = decode(data)
 		if err != nil {
-			return err
+			if source == "" {
+				source = "unknown source"
+			}
+			return fmt.Errorf("%s: %w: %w", source, ErrUnparseable, err)
 		}
 		if cert == nil {
 			break
 		}
-		log.Debugf("Importing %s", cert.Subject.String())
-		certs = append(certs, cert)
+		keep, err := options.check(source, cert)
+		if err != nil {
+			return err
+		}
+		if keep {
+			certs = append(certs, cert)
+		}
 	}
 
-	bundle.certs = append(bundle.certs, certs...)
+	for _, cert := range certs {
+		bundle.add(source, cert)
+	}
 	bundle.changedAt = time.Now()
 
 	return nil
 }
 
+// Add a certificate to the bundle, unless an identical certificate is already present.
+func (bundle *Bundle) add(source string, cert *x509.Certificate) {
+	fingerprint := Fingerprint(cert)
+	sources, exists := bundle.sources[fingerprint]
+	if source != "" && !slices.Contains(sources, source) {
+		sources = append(sources, source)
+	}
+	bundle.sources[fingerprint] = sources
+	if exists {
+		log.Debugf("Skipping duplicate %s", cert.Subject.String())
+		bundle.duplicates++
+		return
+	}
+	log.Debugf("Importing %s", cert.Subject.String())
+	bundle.certs = append(bundle.certs, cert)
+}
+
 func (bundle *Bundle) KeyStore() keystore.KeyStore {
 	gentime := time.Now()
 	ks := keystore.KeyStore{}
 	for i, cert := range bundle.certs {
-		name := fmt.Sprintf("%04d_%s", i, certificateAlias(cert))
+		name := keyStoreAlias(i, cert)
 		entry := &keystore.TrustedCertificateEntry{
 			Entry: keystore.Entry{
 				CreationDate: gentime,
@@ -95,6 +157,28 @@ func (bundle *Bundle) WriteJKS(w io.Writer) error {
 	return keystore.Encode(w, bundle.KeyStore(), []byte(bundle.password))
 }
 
+// Entries of the PKCS#12 store, named with the same aliases as the JKS keystore.
+func (bundle *Bundle) trustStoreEntries() []pkcs12.TrustStoreEntry {
+	entries := make([]pkcs12.TrustStoreEntry, len(bundle.certs))
+	for i, cert := range bundle.certs {
+		entries[i] = pkcs12.TrustStoreEntry{
+			Cert:         cert,
+			FriendlyName: keyStoreAlias(i, cert),
+		}
+	}
+	return entries
+}
+
+// WritePKCS12 writes a trust-only PKCS#12 store, using the same aliases as the JKS keystore.
+func (bundle *Bundle) WritePKCS12(w io.Writer) error {
+	data, err := pkcs12.Modern.EncodeTrustStoreEntries(bundle.trustStoreEntries(), bundle.password)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
 func (bundle *Bundle) WritePEM(w io.Writer) error {
 	for _, cert := range bundle.certs {
 		err := pem.Encode(w, &pem.Block{
@@ -121,6 +205,16 @@ func (bundle *Bundle) Len() int {
 	return len(bundle.certs)
 }
 
+// Duplicates returns how many certificates were left out of the bundle because they were already present.
+func (bundle *Bundle) Duplicates() int {
+	return bundle.duplicates
+}
+
+// Sources returns every source that contributed a given certificate.
+func (bundle *Bundle) Sources(cert *x509.Certificate) []string {
+	return slices.Clone(bundle.sources[Fingerprint(cert)])
+}
+
 func (bundle *Bundle) Hash() []byte {
 	hasher := sha256.New()
 	for _, cert := range bundle.certs {
@@ -129,6 +223,15 @@ func (bundle *Bundle) Hash() []byte {
 	return hasher.Sum(nil)
 }
 
+// Iterations of PBKDF2 when hashing the keystore password, to make guessing it from a published hash expensive.
+const passwordHashIterations = 100_000
+
+// PasswordHash derives a digest of the password protecting the JKS and PKCS#12 stores from the given salt,
+// so that password changes can be detected without publishing the password.
+func (bundle *Bundle) PasswordHash(salt []byte) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, bundle.password, salt, passwordHashIterations, sha256.Size)
+}
+
 func (bundle *Bundle) Equal(b *Bundle) bool {
 	h1 := bundle.Hash()
 	h2 := b.Hash()
@@ -142,6 +245,11 @@ func (bundle *Bundle) ChangedAt() time.Time {
 	return bundle.changedAt
 }
 
+// Generate a unique keystore alias for the certificate at a given position in the bundle.
+func keyStoreAlias(index int, cert *x509.Certificate) string {
+	return fmt.Sprintf("%04d_%s", index, certificateAlias(cert))
+}
+
 // Generate a keytool compatible alias for a certificate.
 // Converts to lowercase and strips away non-alphanumeric characters.
 // In case no CN is defined, this function generates a name based on the signature data.
@@ -8,6 +8,7 @@ import (
 
 	"github.com/nais/certificator/pkg/certbundle"
 	"github.com/stretchr/testify/assert"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 const password = "foobar"
@@ -54,6 +55,39 @@ func TestWrite(t *testing.T) {
 	t.Logf("PEM bundle encoded into memory")
 }
 
+func TestWritePKCS12(t *testing.T) {
+	bundle := bundleFromTestData()
+	readFile(bundle, "../../testdata/cacert.pem")
+	assert.Positive(t, bundle.Duplicates())
+
+	p12out := &bytes.Buffer{}
+	err := bundle.WritePKCS12(p12out)
+	assert.NoError(t, err)
+
+	certs, err := pkcs12.DecodeTrustStore(p12out.Bytes(), password)
+	assert.NoError(t, err)
+
+	expected := bundle.Certificates()
+	assert.Len(t, expected, bundle.Len())
+	if assert.Len(t, certs, len(expected)) {
+		for i := range expected {
+			assert.Equal(t, expected[i].Raw, certs[i].Raw)
+		}
+	}
+
+	// The friendly names are encrypted along with the certificates, so check the entries that were encoded.
+	entries := bundle.TrustStoreEntries()
+	keyStore := bundle.KeyStore()
+	if assert.Len(t, entries, len(certs)) {
+		for i, entry := range entries {
+			alias := certbundle.KeyStoreAlias(i, certs[i])
+			assert.Equal(t, alias, entry.FriendlyName)
+			assert.Contains(t, keyStore, alias, "PKCS#12 entries must be named like the JKS aliases")
+		}
+	}
+	assert.Equal(t, "0000_globalsign_root_ca", entries[0].FriendlyName)
+}
+
 func TestEqual(t *testing.T) {
 	const expectedHash = "d0a2624c7600d1a72e9b4a3c7c2d8d8b2202283789de3cd98d64d80f9cc0ba68"
 
@@ -66,17 +100,57 @@ func TestEqual(t *testing.T) {
 	assert.True(t, b1.Equal(b2))
 	assert.True(t, b2.Equal(b1))
 
-	f, err := os.Open("../../testdata/cacert.pem")
+	readFile(b1, "../../testdata/cacert.pem")
+
+	assert.True(t, b1.Equal(b2), "reading the same certificates twice must not change the bundle")
+	assert.True(t, b2.Equal(b1))
+
+	readFile(b1, "../../testdata/nav-issuing.cer")
+
+	assert.False(t, b1.Equal(b2))
+	assert.False(t, b2.Equal(b1))
+}
+
+func TestDuplicates(t *testing.T) {
+	const first, second = "first", "second"
+
+	bundle := certbundle.New(password)
+	readFileFrom(bundle, first, "../../testdata/cacert.pem")
+	length := bundle.Len()
+	assert.Equal(t, 0, bundle.Duplicates())
+
+	readFileFrom(bundle, second, "../../testdata/cacert.pem")
+	assert.Equal(t, length, bundle.Len())
+	assert.Equal(t, length, bundle.Duplicates())
+
+	for _, cert := range bundle.Certificates() {
+		assert.Equal(t, []string{first, second}, bundle.Sources(cert))
+	}
+
+	pemout := &bytes.Buffer{}
+	err := bundle.WritePEM(pemout)
+	assert.NoError(t, err)
+
+	reread := certbundle.New(password)
+	err = reread.ReadAll(pemout)
+	assert.NoError(t, err)
+	assert.Equal(t, length, reread.Len())
+	assert.True(t, bundle.Equal(reread))
+}
+
+func readFile(bundle *certbundle.Bundle, path string) {
+	readFileFrom(bundle, "", path)
+}
+
+func readFileFrom(bundle *certbundle.Bundle, source, path string) {
+	f, err := os.Open(path)
 	if err != nil {
 		panic(err)
 	}
 	defer func() { _ = f.Close() }()
 
-	err = b1.ReadAll(f)
+	err = bundle.ReadAllFrom(source, f)
 	if err != nil {
 		panic(err)
 	}
-
-	assert.False(t, b1.Equal(b2))
-	assert.False(t, b2.Equal(b1))
 }
@@ -0,0 +1,17 @@
+package certbundle
+
+import (
+	"crypto/x509"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// KeyStoreAlias exposes the alias given to a certificate in the keystores.
+func KeyStoreAlias(index int, cert *x509.Certificate) string {
+	return keyStoreAlias(index, cert)
+}
+
+// TrustStoreEntries exposes the entries written to the PKCS#12 store.
+func (bundle *Bundle) TrustStoreEntries() []pkcs12.TrustStoreEntry {
+	return bundle.trustStoreEntries()
+}
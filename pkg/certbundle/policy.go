@@ -0,0 +1,174 @@
+package certbundle
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Policy decides what happens to a certificate that fails one of the bundle's checks.
+type Policy int
+
+const (
+	PolicyKeep Policy = iota // Add the certificate to the bundle without further notice.
+	PolicyWarn               // Add the certificate to the bundle, and log a warning.
+	PolicyDrop               // Leave the certificate out of the bundle, and log a warning.
+	PolicyFail               // Refuse to read the source containing the certificate.
+)
+
+var policyNames = map[Policy]string{
+	PolicyKeep: "keep",
+	PolicyWarn: "warn",
+	PolicyDrop: "drop",
+	PolicyFail: "fail",
+}
+
+func (policy Policy) String() string {
+	name, ok := policyNames[policy]
+	if !ok {
+		return fmt.Sprintf("Policy(%d)", int(policy))
+	}
+	return name
+}
+
+func (policy *Policy) Decode(value string) error {
+	for p, name := range policyNames {
+		if name == value {
+			*policy = p
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported policy %q, expected one of %q, %q, %q or %q", value, "keep", "warn", "drop", "fail")
+}
+
+func (policy *Policy) UnmarshalText(text []byte) error {
+	return policy.Decode(string(text))
+}
+
+// Option configures how a bundle treats the certificates it reads.
+type Option func(*options)
+
+type options struct {
+	validity     Policy
+	ca           Policy
+	fingerprints map[string]bool
+	now          func() time.Time
+}
+
+func defaultOptions() options {
+	return options{
+		validity: PolicyKeep,
+		ca:       PolicyKeep,
+		now:      time.Now,
+	}
+}
+
+// WithValidityPolicy sets the policy for certificates that are expired or not yet valid.
+func WithValidityPolicy(policy Policy) Option {
+	return func(o *options) {
+		o.validity = policy
+	}
+}
+
+// WithCAPolicy sets the policy for certificates that are not allowed to act as a certificate authority.
+func WithCAPolicy(policy Policy) Option {
+	return func(o *options) {
+		o.ca = policy
+	}
+}
+
+// WithFingerprints only accepts certificates with one of the given SHA-256 fingerprints, in hex with or without colons.
+// Any other certificate fails the read with ErrNotPinned, regardless of policies.
+func WithFingerprints(fingerprints ...string) Option {
+	return func(o *options) {
+		o.fingerprints = make(map[string]bool, len(fingerprints))
+		for _, fingerprint := range fingerprints {
+			o.fingerprints[NormalizeFingerprint(fingerprint)] = true
+		}
+	}
+}
+
+// ErrNotPinned is returned when reading a certificate that isn't in the list given to WithFingerprints.
+var ErrNotPinned = errors.New("certificate fingerprint not pinned")
+
+// NormalizeFingerprint converts a hex fingerprint, as printed by e.g. openssl, to the format returned by Fingerprint.
+func NormalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
+
+// Apply a policy to a certificate that failed a check.
+// Returns true if the certificate should be added to the bundle.
+func enforce(policy Policy, source string, cert *x509.Certificate, reason string) (bool, error) {
+	if source == "" {
+		source = "unknown source"
+	}
+	switch policy {
+	case PolicyWarn:
+		log.Warnf("Certificate %s from %s %s", cert.Subject.String(), source, reason)
+	case PolicyDrop:
+		log.Warnf("Dropping certificate %s from %s: it %s", cert.Subject.String(), source, reason)
+		return false, nil
+	case PolicyFail:
+		return false, fmt.Errorf("certificate %s from %s %s", cert.Subject.String(), source, reason)
+	}
+	return true, nil
+}
+
+// Run all checks on a certificate, stopping at the first one that rejects it.
+// Returns true if the certificate should be added to the bundle.
+func (o *options) check(source string, cert *x509.Certificate) (bool, error) {
+	for _, check := range []func(string, *x509.Certificate) (bool, error){
+		o.checkPinned,
+		o.checkValidity,
+		o.checkCA,
+	} {
+		keep, err := check(source, cert)
+		if err != nil || !keep {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Check that a certificate is one of the pinned certificates, if any.
+func (o *options) checkPinned(source string, cert *x509.Certificate) (bool, error) {
+	if o.fingerprints == nil {
+		return true, nil
+	}
+	fingerprint := Fingerprint(cert)
+	if !o.fingerprints[fingerprint] {
+		if source == "" {
+			source = "unknown source"
+		}
+		return false, fmt.Errorf("certificate %s with fingerprint %s from %s: %w", cert.Subject.String(), fingerprint, source, ErrNotPinned)
+	}
+	return true, nil
+}
+
+// Check that a certificate is valid at the current time.
+func (o *options) checkValidity(source string, cert *x509.Certificate) (bool, error) {
+	now := o.now()
+	if now.After(cert.NotAfter) {
+		return enforce(o.validity, source, cert, fmt.Sprintf("expired at %s", cert.NotAfter.Format(time.RFC3339)))
+	}
+	if now.Before(cert.NotBefore) {
+		return enforce(o.validity, source, cert, fmt.Sprintf("is not valid before %s", cert.NotBefore.Format(time.RFC3339)))
+	}
+	return true, nil
+}
+
+// Check that a certificate is allowed to sign other certificates.
+// Certificates without the KeyUsage extension are not restricted by it.
+func (o *options) checkCA(source string, cert *x509.Certificate) (bool, error) {
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return enforce(o.ca, source, cert, "is not a CA certificate (BasicConstraints CA=false)")
+	}
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return enforce(o.ca, source, cert, "has a KeyUsage that does not permit certificate signing")
+	}
+	return true, nil
+}
@@ -0,0 +1,127 @@
+package certbundle_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/nais/certificator/pkg/certbundle"
+	"github.com/stretchr/testify/assert"
+)
+
+// Generate a self-signed CA certificate in PEM format, optionally modified before signing.
+func selfSigned(t *testing.T, commonName string, modify func(*x509.Certificate)) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	if modify != nil {
+		modify(template)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestValidityPolicy(t *testing.T) {
+	valid := selfSigned(t, "valid", nil)
+	expired := selfSigned(t, "expired", func(cert *x509.Certificate) {
+		cert.NotBefore = time.Now().Add(-2 * time.Hour)
+		cert.NotAfter = time.Now().Add(-time.Hour)
+	})
+	premature := selfSigned(t, "premature", func(cert *x509.Certificate) {
+		cert.NotBefore = time.Now().Add(time.Hour)
+		cert.NotAfter = time.Now().Add(2 * time.Hour)
+	})
+	data := bytes.Join([][]byte{valid, expired, premature}, nil)
+
+	for _, test := range []struct {
+		policy   certbundle.Policy
+		expected int
+		err      bool
+	}{
+		{policy: certbundle.PolicyKeep, expected: 3},
+		{policy: certbundle.PolicyWarn, expected: 3},
+		{policy: certbundle.PolicyDrop, expected: 1},
+		{policy: certbundle.PolicyFail, expected: 0, err: true},
+	} {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			bundle := certbundle.New(password, certbundle.WithValidityPolicy(test.policy))
+			err := bundle.ReadAllFrom("test", bytes.NewReader(data))
+			if test.err {
+				assert.ErrorContains(t, err, "CN=expired from test expired at")
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.expected, bundle.Len())
+		})
+	}
+}
+
+func TestPolicyDecode(t *testing.T) {
+	var policy certbundle.Policy
+
+	for _, name := range []string{"keep", "warn", "drop", "fail"} {
+		err := policy.Decode(name)
+		assert.NoError(t, err)
+		assert.Equal(t, name, policy.String())
+	}
+
+	err := policy.Decode("ignore")
+	assert.Error(t, err)
+}
+
+func TestCAPolicy(t *testing.T) {
+	ca := selfSigned(t, "ca", nil)
+	leaf := selfSigned(t, "leaf", func(cert *x509.Certificate) {
+		cert.IsCA = false
+		cert.KeyUsage = x509.KeyUsageDigitalSignature
+	})
+	noCertSign := selfSigned(t, "no-cert-sign", func(cert *x509.Certificate) {
+		cert.KeyUsage = x509.KeyUsageDigitalSignature
+	})
+	noKeyUsage := selfSigned(t, "no-key-usage", func(cert *x509.Certificate) {
+		cert.KeyUsage = 0
+	})
+	data := bytes.Join([][]byte{ca, leaf, noCertSign, noKeyUsage}, nil)
+
+	for _, test := range []struct {
+		policy   certbundle.Policy
+		expected int
+		err      bool
+	}{
+		{policy: certbundle.PolicyKeep, expected: 4},
+		{policy: certbundle.PolicyWarn, expected: 4},
+		{policy: certbundle.PolicyDrop, expected: 2},
+		{policy: certbundle.PolicyFail, expected: 0, err: true},
+	} {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			bundle := certbundle.New(password, certbundle.WithCAPolicy(test.policy))
+			err := bundle.ReadAllFrom("/etc/certs/leaf.pem", bytes.NewReader(data))
+			if test.err {
+				assert.ErrorContains(t, err, "CN=leaf from /etc/certs/leaf.pem is not a CA certificate")
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.expected, bundle.Len())
+		})
+	}
+}
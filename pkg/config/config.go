@@ -3,26 +3,51 @@ package config
 import (
 	"fmt"
 	"os"
-	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/nais/certificator/pkg/certbundle"
+	"github.com/nais/certificator/pkg/loader"
+	"github.com/nais/certificator/pkg/resource"
 )
 
 type Config struct {
-	CAUrls                 []string      `split_words:"true"`
-	CADirectories          []string      `split_words:"true"`
-	DownloadTimeout        time.Duration `split_words:"true" default:"5s"`
-	DownloadInterval       time.Duration `split_words:"true" default:"24h"`
-	DownloadRetryInterval  time.Duration `split_words:"true" default:"10m"`
-	ApplyBackoff           time.Duration `split_words:"true" default:"5m"`
-	ApplyTimeout           time.Duration `split_words:"true" default:"10s"`
-	JksPassword            string        `split_words:"true" default:"changeme" required:"true"`
-	LogFormat              LogFormat     `split_words:"true" default:"text" required:"true"`
-	LogLevel               LogLevel      `split_words:"true" default:"debug" required:"true"`
-	MetricsAddress         string        `split_words:"true" default:"127.0.0.1:8080"`
-	NamespaceLabelSelector string        `split_words:"true" default:"team"`
+	ConfigFile               string            `split_words:"true"`
+	CAUrls                   []string          `split_words:"true"`
+	CADirectories            []string          `split_words:"true"`
+	DownloadTimeout          time.Duration     `split_words:"true" default:"5s"`
+	DownloadInterval         time.Duration     `split_words:"true" default:"24h"`
+	DownloadRetryInterval    time.Duration     `split_words:"true" default:"10m"`
+	DownloadMaxStaleness     time.Duration     `split_words:"true" default:"72h"`
+	DownloadCacheDirectory   string            `split_words:"true"`
+	ApplyBackoff             time.Duration     `split_words:"true" default:"5m"`
+	ApplyTimeout             time.Duration     `split_words:"true" default:"10s"`
+	ApplyForceConflicts      bool              `split_words:"true" default:"true"`
+	JksPassword              string            `split_words:"true" default:"changeme" required:"true"`
+	LogFormat                LogFormat         `split_words:"true" default:"text" required:"true"`
+	LogLevel                 LogLevel          `split_words:"true" default:"debug" required:"true"`
+	MetricsAddress           string            `split_words:"true" default:"127.0.0.1:8080"`
+	HealthStalenessThreshold time.Duration     `split_words:"true" default:"72h"`
+	NamespaceLabelSelector   string            `split_words:"true" default:"team"`
+	ResyncInterval           time.Duration     `split_words:"true" default:"1h"`
+	ValidityPolicy           certbundle.Policy `split_words:"true" default:"warn"`
+	CAPolicy                 certbundle.Policy `split_words:"true" default:"drop"`
+	ResourceKinds            []resource.Kind   `split_words:"true" default:"configmap"`
+
+	LeaderElection              bool          `split_words:"true" default:"false"`
+	LeaderElectionLeaseName     string        `split_words:"true" default:"certificator"`
+	LeaderElectionNamespace     string        `split_words:"true"`
+	LeaderElectionLeaseDuration time.Duration `split_words:"true" default:"15s"`
+	LeaderElectionRenewDeadline time.Duration `split_words:"true" default:"10s"`
+	LeaderElectionRetryPeriod   time.Duration `split_words:"true" default:"2s"`
+
+	// All sources, from environment variables and the configuration file. Populated by Validate.
+	Sources []loader.Source `ignored:"true"`
+
+	file *File
 }
 
 type LogFormat struct {
@@ -62,30 +87,95 @@ func NewFromEnv() (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	if cfg.ConfigFile != "" {
+		cfg.file, err = ReadFile(cfg.ConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("config file %w", err)
+		}
+		cfg.file.apply(cfg)
+	}
 	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
 	return cfg, nil
 }
 
+// Reload reads the configuration again, and replaces the sources with the new ones.
+// Other settings only take effect on restart; the names of those that differ from the running
+// configuration are returned, so that they can be reported. The configuration is left unchanged on error.
+func (cfg *Config) Reload() ([]string, error) {
+	reloaded, err := NewFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	ignored := make([]string, 0)
+	if !slices.Equal(reloaded.ResourceKinds, cfg.ResourceKinds) {
+		ignored = append(ignored, "output.resourceKinds")
+	}
+	if reloaded.ApplyForceConflicts != cfg.ApplyForceConflicts {
+		ignored = append(ignored, "output.applyForceConflicts")
+	}
+	cfg.Sources = reloaded.Sources
+	return ignored, nil
+}
+
 func (cfg *Config) Validate() error {
-	if len(cfg.CAUrls)+len(cfg.CADirectories) == 0 {
-		return fmt.Errorf("no CA certificate sources configured")
+	if len(cfg.ResourceKinds) == 0 {
+		return fmt.Errorf("no resource kinds configured")
 	}
+	if cfg.LeaderElection {
+		if cfg.LeaderElectionNamespace == "" {
+			return fmt.Errorf("leader election requires a lease namespace")
+		}
+		if cfg.LeaderElectionRenewDeadline >= cfg.LeaderElectionLeaseDuration {
+			return fmt.Errorf("leader election renew deadline %s must be shorter than lease duration %s", cfg.LeaderElectionRenewDeadline, cfg.LeaderElectionLeaseDuration)
+		}
+		if cfg.LeaderElectionRetryPeriod >= cfg.LeaderElectionRenewDeadline {
+			return fmt.Errorf("leader election retry period %s must be shorter than renew deadline %s", cfg.LeaderElectionRetryPeriod, cfg.LeaderElectionRenewDeadline)
+		}
+	}
+	if cfg.DownloadCacheDirectory != "" {
+		stat, err := os.Stat(cfg.DownloadCacheDirectory)
+		if err != nil {
+			return fmt.Errorf("download cache directory: %w", err)
+		}
+		if !stat.IsDir() {
+			return fmt.Errorf("%s is not a directory", cfg.DownloadCacheDirectory)
+		}
+	}
+
+	cfg.Sources = make([]loader.Source, 0, len(cfg.CADirectories)+len(cfg.CAUrls))
 	for i, p := range cfg.CADirectories {
-		absPath, err := filepath.Abs(p)
+		absPath, err := directory(p)
 		if err != nil {
 			return err
 		}
 		cfg.CADirectories[i] = absPath
-		stat, err := os.Stat(absPath)
+		cfg.Sources = append(cfg.Sources, loader.Source{
+			Type: loader.SourceDirectory,
+			Path: absPath,
+		})
+	}
+	for _, raw := range cfg.CAUrls {
+		source, err := loader.ParseSource(raw)
 		if err != nil {
 			return err
 		}
-		if !stat.IsDir() {
-			return fmt.Errorf("%s is not a directory", absPath)
+		source.Timeout = cfg.DownloadTimeout
+		cfg.Sources = append(cfg.Sources, source)
+	}
+	if cfg.file != nil {
+		for i, fs := range cfg.file.Sources {
+			source, err := fs.source(cfg.DownloadTimeout)
+			if err != nil {
+				return fmt.Errorf("config file %s: sources[%d]: %w", cfg.ConfigFile, i, err)
+			}
+			cfg.Sources = append(cfg.Sources, source)
 		}
 	}
+	if len(cfg.Sources) == 0 {
+		return fmt.Errorf("no CA certificate sources configured")
+	}
 	return nil
 }
 
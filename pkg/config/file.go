@@ -0,0 +1,380 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+
+	"github.com/nais/certificator/pkg/certbundle"
+	"github.com/nais/certificator/pkg/loader"
+	"github.com/nais/certificator/pkg/resource"
+)
+
+// File is the structure of the configuration file, in YAML or JSON.
+// Sources are added to the ones configured with environment variables,
+// and output settings override environment variables when set.
+type File struct {
+	Sources []FileSource `json:"sources"`
+	Output  FileOutput   `json:"output"`
+}
+
+// FileSource configures a single source of certificates.
+// The type is inferred from whether url, path or namespace is set, if not given.
+type FileSource struct {
+	Type           loader.SourceType    `json:"type"`
+	URL            string               `json:"url"`
+	Path           string               `json:"path"`
+	Recursive      bool                 `json:"recursive"`
+	Include        []string             `json:"include"`
+	Exclude        []string             `json:"exclude"`
+	Symlinks       loader.SymlinkPolicy `json:"symlinks"`
+	Lenient        bool                 `json:"lenient"`
+	Kind           resource.Kind        `json:"kind"`
+	Namespace      string               `json:"namespace"`
+	Name           string               `json:"name"`
+	LabelSelector  string               `json:"labelSelector"`
+	Keys           []string             `json:"keys"`
+	Timeout        *Duration            `json:"timeout"`
+	Auth           *FileAuth            `json:"auth"`
+	SHA256         string               `json:"sha256"`
+	Fingerprints   []string             `json:"fingerprints"`
+	Checksum       string               `json:"checksum"`
+	Signature      string               `json:"signature"`
+	PublicKey      string               `json:"publicKey"`
+	ValidityPolicy *certbundle.Policy   `json:"validityPolicy"`
+	CAPolicy       *certbundle.Policy   `json:"caPolicy"`
+	Tags           []string             `json:"tags"`
+}
+
+// FileAuth configures credentials for a URL source.
+type FileAuth struct {
+	BearerTokenFile string            `json:"bearerTokenFile"`
+	Username        string            `json:"username"`
+	PasswordFile    string            `json:"passwordFile"`
+	Headers         map[string]string `json:"headers"`
+}
+
+// FileOutput configures how the bundle is written to namespaces.
+type FileOutput struct {
+	ResourceKinds       []resource.Kind `json:"resourceKinds"`
+	ApplyForceConflicts *bool           `json:"applyForceConflicts"`
+}
+
+// Duration is a time.Duration written as a string, e.g. "30s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\"")
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// ReadFile reads a configuration file. Unknown fields are rejected.
+func ReadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := &File{}
+	err = yaml.UnmarshalStrict(data, file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return file, nil
+}
+
+// Override settings configured with environment variables.
+func (file *File) apply(cfg *Config) {
+	if file.Output.ResourceKinds != nil {
+		cfg.ResourceKinds = file.Output.ResourceKinds
+	}
+	if file.Output.ApplyForceConflicts != nil {
+		cfg.ApplyForceConflicts = *file.Output.ApplyForceConflicts
+	}
+}
+
+// Convert a source from the configuration file to a loader source, and check that it is complete and consistent.
+func (fs FileSource) source(defaultTimeout time.Duration) (loader.Source, error) {
+	sourceType := fs.Type
+	if sourceType == "" {
+		inferred := make([]loader.SourceType, 0)
+		if fs.URL != "" {
+			inferred = append(inferred, loader.SourceURL)
+		}
+		if fs.Path != "" {
+			inferred = append(inferred, loader.SourceDirectory)
+		}
+		if fs.Namespace != "" {
+			inferred = append(inferred, loader.SourceKubernetes)
+		}
+		if len(inferred) != 1 {
+			return loader.Source{}, fmt.Errorf("exactly one of url, path and namespace must be set, or type given")
+		}
+		sourceType = inferred[0]
+	}
+
+	var source loader.Source
+	var err error
+	switch sourceType {
+	case loader.SourceURL:
+		source, err = fs.urlSource(defaultTimeout)
+	case loader.SourceDirectory:
+		source, err = fs.directorySource()
+	case loader.SourceKubernetes:
+		source, err = fs.kubernetesSource(defaultTimeout)
+	default:
+		return loader.Source{}, fmt.Errorf("unsupported type %q, expected %q, %q or %q", sourceType, loader.SourceURL, loader.SourceDirectory, loader.SourceKubernetes)
+	}
+	if err != nil {
+		return loader.Source{}, err
+	}
+
+	if len(fs.Fingerprints) > 0 {
+		source.Fingerprints = fs.Fingerprints
+	}
+	source.ValidityPolicy = fs.ValidityPolicy
+	source.CAPolicy = fs.CAPolicy
+	source.Tags = fs.Tags
+	return source, nil
+}
+
+func (fs FileSource) urlSource(defaultTimeout time.Duration) (loader.Source, error) {
+	if fs.URL == "" {
+		return loader.Source{}, fmt.Errorf("url must be set for %s sources", loader.SourceURL)
+	}
+	err := unsupported(loader.SourceURL, fs.directoryFields(), fs.kubernetesFields(), []field{{"lenient", fs.Lenient}})
+	if err != nil {
+		return loader.Source{}, err
+	}
+	source, err := loader.ParseSource(fs.URL)
+	if err != nil {
+		return loader.Source{}, err
+	}
+
+	source.Timeout = fs.timeout(defaultTimeout)
+	if fs.SHA256 != "" {
+		source.SHA256 = strings.ToLower(fs.SHA256)
+	}
+
+	base, _ := url.Parse(source.URL)
+	resolve := func(field, value string) (string, error) {
+		ref, err := url.Parse(value)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", field, err)
+		}
+		return base.ResolveReference(ref).String(), nil
+	}
+	if fs.Checksum != "" {
+		source.ChecksumURL, err = resolve("checksum", fs.Checksum)
+		if err != nil {
+			return loader.Source{}, err
+		}
+	}
+	if fs.Signature != "" {
+		source.SignatureURL, err = resolve("signature", fs.Signature)
+		if err != nil {
+			return loader.Source{}, err
+		}
+	}
+	if fs.PublicKey != "" {
+		source.PublicKey, err = loader.ReadPublicKey(fs.PublicKey)
+		if err != nil {
+			return loader.Source{}, fmt.Errorf("publicKey: %w", err)
+		}
+	}
+	if (source.SignatureURL == "") != (source.PublicKey == nil) {
+		return loader.Source{}, fmt.Errorf("signature and publicKey must be set together")
+	}
+
+	if fs.Auth != nil {
+		source.Auth, err = fs.Auth.auth()
+		if err != nil {
+			return loader.Source{}, fmt.Errorf("auth: %w", err)
+		}
+	}
+	return source, nil
+}
+
+// Fields that only apply to some source types, by whether they are set.
+type field struct {
+	name string
+	set  bool
+}
+
+func (fs FileSource) urlFields() []field {
+	return []field{
+		{"url", fs.URL != ""},
+		{"auth", fs.Auth != nil},
+		{"sha256", fs.SHA256 != ""},
+		{"checksum", fs.Checksum != ""},
+		{"signature", fs.Signature != ""},
+		{"publicKey", fs.PublicKey != ""},
+	}
+}
+
+func (fs FileSource) directoryFields() []field {
+	return []field{
+		{"path", fs.Path != ""},
+		{"recursive", fs.Recursive},
+		{"include", fs.Include != nil},
+		{"exclude", fs.Exclude != nil},
+		{"symlinks", fs.Symlinks != ""},
+	}
+}
+
+func (fs FileSource) kubernetesFields() []field {
+	return []field{
+		{"kind", fs.Kind != ""},
+		{"namespace", fs.Namespace != ""},
+		{"name", fs.Name != ""},
+		{"labelSelector", fs.LabelSelector != ""},
+		{"keys", fs.Keys != nil},
+	}
+}
+
+// Check that none of the given fields are set for a source of the given type.
+func unsupported(sourceType loader.SourceType, fieldSets ...[]field) error {
+	for _, fields := range fieldSets {
+		for _, f := range fields {
+			if f.set {
+				return fmt.Errorf("%s is not supported for %s sources", f.name, sourceType)
+			}
+		}
+	}
+	return nil
+}
+
+// Return the timeout for reading the source, or the given default if not set.
+func (fs FileSource) timeout(defaultTimeout time.Duration) time.Duration {
+	if fs.Timeout != nil {
+		return time.Duration(*fs.Timeout)
+	}
+	return defaultTimeout
+}
+
+func (fs FileSource) kubernetesSource(defaultTimeout time.Duration) (loader.Source, error) {
+	err := unsupported(loader.SourceKubernetes, fs.urlFields(), fs.directoryFields())
+	if err != nil {
+		return loader.Source{}, err
+	}
+	if fs.Namespace == "" {
+		return loader.Source{}, fmt.Errorf("namespace must be set for %s sources", loader.SourceKubernetes)
+	}
+	if fs.Kind == "" {
+		return loader.Source{}, fmt.Errorf("kind must be set for %s sources", loader.SourceKubernetes)
+	}
+	if (fs.Name == "") == (fs.LabelSelector == "") {
+		return loader.Source{}, fmt.Errorf("exactly one of name and labelSelector must be set")
+	}
+	if fs.LabelSelector != "" {
+		_, err = labels.Parse(fs.LabelSelector)
+		if err != nil {
+			return loader.Source{}, fmt.Errorf("labelSelector: %w", err)
+		}
+	}
+	return loader.Source{
+		Type: loader.SourceKubernetes,
+		Kubernetes: loader.KubernetesSource{
+			Kind:          fs.Kind,
+			Namespace:     fs.Namespace,
+			Name:          fs.Name,
+			LabelSelector: fs.LabelSelector,
+			Keys:          fs.Keys,
+			Lenient:       fs.Lenient,
+		},
+		Timeout: fs.timeout(defaultTimeout),
+	}, nil
+}
+
+func (fs FileSource) directorySource() (loader.Source, error) {
+	if fs.Path == "" {
+		return loader.Source{}, fmt.Errorf("path must be set for %s sources", loader.SourceDirectory)
+	}
+	err := unsupported(loader.SourceDirectory, fs.urlFields(), fs.kubernetesFields(), []field{{"timeout", fs.Timeout != nil}})
+	if err != nil {
+		return loader.Source{}, err
+	}
+	for _, pattern := range slices.Concat(fs.Include, fs.Exclude) {
+		_, err = filepath.Match(pattern, "")
+		if err != nil {
+			return loader.Source{}, fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+	}
+	path, err := directory(fs.Path)
+	if err != nil {
+		return loader.Source{}, err
+	}
+	return loader.Source{
+		Type: loader.SourceDirectory,
+		Path: path,
+		Directory: loader.DirectorySource{
+			Recursive: fs.Recursive,
+			Include:   fs.Include,
+			Exclude:   fs.Exclude,
+			Symlinks:  fs.Symlinks,
+			Lenient:   fs.Lenient,
+		},
+	}, nil
+}
+
+func (fa FileAuth) auth() (loader.Auth, error) {
+	if fa.PasswordFile != "" && fa.Username == "" {
+		return loader.Auth{}, fmt.Errorf("passwordFile requires username")
+	}
+	if fa.BearerTokenFile != "" && fa.Username != "" {
+		return loader.Auth{}, fmt.Errorf("only one of bearerTokenFile and username may be set")
+	}
+	for _, file := range []struct {
+		field string
+		path  string
+	}{
+		{"bearerTokenFile", fa.BearerTokenFile},
+		{"passwordFile", fa.PasswordFile},
+	} {
+		if file.path == "" {
+			continue
+		}
+		_, err := os.Stat(file.path)
+		if err != nil {
+			return loader.Auth{}, fmt.Errorf("%s: %w", file.field, err)
+		}
+	}
+	return loader.Auth{
+		BearerTokenFile: fa.BearerTokenFile,
+		Username:        fa.Username,
+		PasswordFile:    fa.PasswordFile,
+		Headers:         fa.Headers,
+	}, nil
+}
+
+// Resolve a directory to an absolute path, and check that it exists.
+func directory(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	stat, err := os.Stat(absPath)
+	if err != nil {
+		return "", err
+	}
+	if !stat.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", absPath)
+	}
+	return absPath, nil
+}
@@ -0,0 +1,153 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nais/certificator/pkg/certbundle"
+	"github.com/nais/certificator/pkg/config"
+	"github.com/nais/certificator/pkg/loader"
+	"github.com/nais/certificator/pkg/resource"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		panic(err)
+	}
+	return path
+}
+
+func TestConfigFile(t *testing.T) {
+	directory := t.TempDir()
+	t.Setenv("CERTIFICATOR_CA_URLS", "https://curl.se/ca/cacert.pem")
+	t.Setenv("CERTIFICATOR_DOWNLOAD_TIMEOUT", "7s")
+	t.Setenv("CERTIFICATOR_CONFIG_FILE", writeConfig(t, `
+sources:
+  - url: https://ca.example.com/internal.pem
+    timeout: 30s
+    checksum: internal.pem.sha256
+    caPolicy: fail
+    tags: [internal]
+    auth:
+      username: certificator
+      headers:
+        X-Team: nais
+  - path: `+directory+`
+    validityPolicy: drop
+    recursive: true
+    include: ["*.crt", "*.pem"]
+    symlinks: within
+    lenient: true
+  - kind: secret
+    namespace: nais-system
+    labelSelector: certificator.nais.io/ca=true
+    keys: [ca.crt]
+    lenient: true
+output:
+  resourceKinds: [configmap, secret]
+  applyForceConflicts: false
+`))
+
+	cfg, err := config.NewFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, []resource.Kind{resource.KindConfigMap, resource.KindSecret}, cfg.ResourceKinds)
+	assert.False(t, cfg.ApplyForceConflicts)
+
+	assert.Len(t, cfg.Sources, 4)
+	assert.Equal(t, "https://curl.se/ca/cacert.pem", cfg.Sources[0].URL)
+	assert.Equal(t, 7*time.Second, cfg.Sources[0].Timeout)
+
+	internal := cfg.Sources[1]
+	assert.Equal(t, loader.SourceURL, internal.Type)
+	assert.Equal(t, 30*time.Second, internal.Timeout)
+	assert.Equal(t, "https://ca.example.com/internal.pem.sha256", internal.ChecksumURL)
+	assert.Equal(t, certbundle.PolicyFail, *internal.CAPolicy)
+	assert.Nil(t, internal.ValidityPolicy)
+	assert.Equal(t, []string{"internal"}, internal.Tags)
+	assert.Equal(t, "certificator", internal.Auth.Username)
+	assert.Equal(t, "nais", internal.Auth.Headers["X-Team"])
+
+	assert.Equal(t, loader.SourceDirectory, cfg.Sources[2].Type)
+	assert.Equal(t, directory, cfg.Sources[2].Path)
+	assert.Equal(t, certbundle.PolicyDrop, *cfg.Sources[2].ValidityPolicy)
+	assert.Equal(t, loader.DirectorySource{
+		Recursive: true,
+		Include:   []string{"*.crt", "*.pem"},
+		Symlinks:  loader.SymlinksWithin,
+		Lenient:   true,
+	}, cfg.Sources[2].Directory)
+
+	assert.Equal(t, loader.SourceKubernetes, cfg.Sources[3].Type)
+	assert.Equal(t, loader.KubernetesSource{
+		Kind:          resource.KindSecret,
+		Namespace:     "nais-system",
+		LabelSelector: "certificator.nais.io/ca=true",
+		Keys:          []string{"ca.crt"},
+		Lenient:       true,
+	}, cfg.Sources[3].Kubernetes)
+	assert.Equal(t, 7*time.Second, cfg.Sources[3].Timeout)
+	assert.Equal(t, "secret/nais-system?labelSelector=certificator.nais.io/ca=true", cfg.Sources[3].String())
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	for content, message := range map[string]string{
+		"sources:\n  - url: https://example.com/ca.pem\n    checksun: ca.sha256\n":           `unknown field "checksun"`,
+		"sources:\n  - url: https://example.com/ca.pem\n    timeout: 30\n":                   "duration must be a string",
+		"sources:\n  - url: https://example.com/ca.pem\n    caPolicy: ignore\n":              `unsupported policy "ignore"`,
+		"sources:\n  - tags: [foo]\n":                                                        "sources[0]: exactly one of url, path and namespace must be set",
+		"sources:\n  - url: https://example.com/a.pem\n  - path: /nonexistent\n":             "sources[1]: stat /nonexistent",
+		"sources:\n  - path: /tmp\n    sha256: abcd\n":                                       "sources[0]: sha256 is not supported for directory sources",
+		"sources:\n  - url: https://example.com/ca.pem\n    signature: ca.sig\n":             "sources[0]: signature and publicKey must be set together",
+		"sources:\n  - url: https://example.com/ca.pem\n    auth:\n      passwordFile: /x\n": "sources[0]: auth: passwordFile requires username",
+		"sources:\n  - namespace: nais\n    name: ca\n":                                      "sources[0]: kind must be set",
+		"sources:\n  - namespace: nais\n    kind: secret\n":                                  "sources[0]: exactly one of name and labelSelector must be set",
+		"sources:\n  - namespace: nais\n    kind: secret\n    labelSelector: 'a in b'\n":     "sources[0]: labelSelector: ",
+		"sources:\n  - namespace: nais\n    kind: secret\n    name: ca\n    sha256: ab\n":    "sources[0]: sha256 is not supported for kubernetes sources",
+		"sources:\n  - path: /tmp\n    keys: [ca.crt]\n":                                     "sources[0]: keys is not supported for directory sources",
+		"sources:\n  - path: /tmp\n    timeout: 5s\n":                                        "sources[0]: timeout is not supported for directory sources",
+		"sources:\n  - path: /tmp\n    exclude: ['[']\n":                                     `sources[0]: pattern "[": syntax error in pattern`,
+		"sources:\n  - path: /tmp\n    symlinks: never\n":                                    `unsupported symlink policy "never"`,
+		"sources:\n  - url: https://example.com/ca.pem\n    recursive: true\n":               "sources[0]: recursive is not supported for url sources",
+		"sources:\n  - url: https://example.com/ca.pem\n    lenient: true\n":                 "sources[0]: lenient is not supported for url sources",
+		"output:\n  resourceKinds: [deployment]\n":                                           `unsupported resource kind "deployment"`,
+		"output:\n  resourceKinds: []\n":                                                     "no resource kinds configured",
+		"sources: []\n":                                                                      "no CA certificate sources configured",
+	} {
+		t.Setenv("CERTIFICATOR_CONFIG_FILE", writeConfig(t, content))
+		_, err := config.NewFromEnv()
+		assert.ErrorContains(t, err, message, content)
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	path := writeConfig(t, "sources:\n  - url: https://example.com/a.pem\n")
+	t.Setenv("CERTIFICATOR_CONFIG_FILE", path)
+	cfg, err := config.NewFromEnv()
+	assert.NoError(t, err)
+
+	rewrite := func(content string) {
+		err := os.WriteFile(path, []byte(content), 0o600)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	rewrite("sources:\n  - url: ftp://example.com/b.pem\n")
+	_, err = cfg.Reload()
+	assert.ErrorContains(t, err, "unsupported scheme")
+	assert.Len(t, cfg.Sources, 1)
+	assert.Equal(t, "https://example.com/a.pem", cfg.Sources[0].URL, "an invalid file must keep the previous sources")
+
+	rewrite("sources:\n  - url: https://example.com/b.pem\n  - url: https://example.com/c.pem\noutput:\n  resourceKinds: [secret]\n")
+	ignored, err := cfg.Reload()
+	assert.NoError(t, err)
+	assert.Len(t, cfg.Sources, 2)
+	assert.Equal(t, "https://example.com/b.pem", cfg.Sources[0].URL)
+	assert.Equal(t, []string{"output.resourceKinds"}, ignored)
+	assert.Equal(t, []resource.Kind{resource.KindConfigMap}, cfg.ResourceKinds, "output settings must not change while running")
+}
@@ -0,0 +1,210 @@
+package fswatch
+
+import (
+	"context"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// Watch files and directories, and send to a channel when any of them change.
+// Bursts of changes within the debounce interval are reported once, after the burst.
+//
+// Files are watched through their parent directory, so that changes are seen even when a file is replaced
+// rather than written to. This includes Kubernetes ConfigMap and Secret volumes, which are updated by
+// swapping the ..data symlink in the volume's root directory.
+// Blocks until the context is canceled, then closes the channel.
+func Watch(ctx context.Context, paths []string, debounce time.Duration, changes chan<- struct{}) error {
+	return WatchTrees(ctx, paths, nil, debounce, changes)
+}
+
+// WatchTrees works like Watch, and also watches the given directories with all their subdirectories,
+// including ones created while watching. Symbolic links to directories and entries with names
+// starting with ".." are not descended into.
+func WatchTrees(ctx context.Context, paths, trees []string, debounce time.Duration, changes chan<- struct{}) error {
+	defer close(changes)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = watcher.Close() }()
+
+	// Names of interesting entries in each watched directory; nil means every entry.
+	filters := make(map[string]map[string]bool)
+	for _, path := range paths {
+		path, err = filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		stat, statErr := os.Stat(path)
+		if statErr != nil {
+			return statErr
+		}
+		if stat.IsDir() {
+			filters[path] = nil
+			continue
+		}
+		directory, name := filepath.Split(path)
+		directory = filepath.Clean(directory)
+		filter, watched := filters[directory]
+		if watched && filter == nil {
+			continue
+		}
+		if filter == nil {
+			filter = make(map[string]bool)
+			filters[directory] = filter
+		}
+		filter[name] = true
+	}
+	for directory := range filters {
+		err = watcher.Add(directory)
+		if err != nil {
+			return err
+		}
+		log.Debugf("Watching %s for changes", directory)
+	}
+
+	// Directories watched as part of a tree, whose new subdirectories are watched as well.
+	inTree := make(map[string]bool)
+	addTree := func(root string) error {
+		return filepath.WalkDir(root, func(path string, entry fs.DirEntry, walkErr error) error {
+			if walkErr != nil {
+				return walkErr
+			}
+			if !entry.IsDir() {
+				return nil
+			}
+			if path != root && strings.HasPrefix(entry.Name(), "..") {
+				return filepath.SkipDir
+			}
+			if inTree[path] {
+				return nil
+			}
+			addErr := watcher.Add(path)
+			if addErr != nil {
+				return addErr
+			}
+			inTree[path] = true
+			filters[path] = nil
+			log.Debugf("Watching %s for changes", path)
+			return nil
+		})
+	}
+	roots := make(map[string]bool)
+	for _, tree := range trees {
+		tree, err = filepath.Abs(tree)
+		if err != nil {
+			return err
+		}
+		err = addTree(tree)
+		if err != nil {
+			return err
+		}
+		roots[tree] = true
+	}
+
+	// Watch the parents of the given directories as well, so that a directory that is removed
+	// and created again is watched again.
+	recreated := make(map[string]bool)
+	for _, directory := range slices.Sorted(maps.Keys(filters)) {
+		if inTree[directory] && !roots[directory] {
+			continue
+		}
+		recreated[directory] = true
+		parent, name := filepath.Split(directory)
+		parent = filepath.Clean(parent)
+		if parent == directory {
+			continue
+		}
+		filter, watched := filters[parent]
+		if watched {
+			if filter != nil {
+				filter[name] = true
+			}
+			continue
+		}
+		addErr := watcher.Add(parent)
+		if addErr != nil {
+			log.Warnf("Watching %s for %s to be created again: %s", parent, directory, addErr)
+			continue
+		}
+		filters[parent] = map[string]bool{name: true}
+	}
+
+	interesting := func(event fsnotify.Event) bool {
+		if event.Op == fsnotify.Chmod {
+			return false
+		}
+		directory, name := filepath.Split(event.Name)
+		filter := filters[filepath.Clean(directory)]
+		return filter == nil || filter[name] || strings.HasPrefix(name, "..")
+	}
+
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if interesting(event) {
+				log.Tracef("File system event: %s", event)
+				timer.Reset(debounce)
+			}
+			switch {
+			case event.Has(fsnotify.Create) && recreated[event.Name]:
+				stat, statErr := os.Stat(event.Name)
+				if statErr != nil || !stat.IsDir() {
+					break
+				}
+				var addErr error
+				if roots[event.Name] {
+					addErr = addTree(event.Name)
+				} else {
+					addErr = watcher.Add(event.Name)
+				}
+				if addErr != nil {
+					log.Warnf("Watching recreated directory %s: %s", event.Name, addErr)
+				}
+			case event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename):
+				// The watch on a removed directory is dropped by fsnotify; it is added again if the directory reappears.
+				delete(inTree, event.Name)
+			case event.Has(fsnotify.Create) && inTree[filepath.Dir(event.Name)] && !strings.HasPrefix(filepath.Base(event.Name), ".."):
+				stat, statErr := os.Lstat(event.Name)
+				if statErr == nil && stat.IsDir() {
+					addErr := addTree(event.Name)
+					if addErr != nil {
+						log.Warnf("Watching new directory %s: %s", event.Name, addErr)
+					}
+				}
+			}
+
+		case watchErr, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Warnf("Watching files: %s", watchErr)
+
+		case <-timer.C:
+			select {
+			case <-ctx.Done():
+				return nil
+			case changes <- struct{}{}:
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package fswatch_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nais/certificator/pkg/fswatch"
+	"github.com/stretchr/testify/assert"
+)
+
+const debounce = 50 * time.Millisecond
+
+func receive(t *testing.T, changes <-chan struct{}) {
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for change")
+	}
+}
+
+func quiet(t *testing.T, changes <-chan struct{}) {
+	select {
+	case <-changes:
+		t.Fatal("unexpected change")
+	case <-time.After(4 * debounce):
+	}
+}
+
+func watch(t *testing.T, ctx context.Context, paths ...string) (<-chan struct{}, <-chan error) {
+	changes := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- fswatch.Watch(ctx, paths, debounce, changes)
+	}()
+	// Give the watcher time to start.
+	time.Sleep(debounce)
+	return changes, done
+}
+
+func write(path, content string) {
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	directory := t.TempDir()
+	path := filepath.Join(directory, "config.yaml")
+	write(path, "a")
+
+	changes, done := watch(t, ctx, path)
+
+	write(filepath.Join(directory, "unrelated"), "x")
+	quiet(t, changes)
+
+	for range 5 {
+		write(path, "b")
+	}
+	receive(t, changes)
+	quiet(t, changes)
+
+	cancel()
+	assert.NoError(t, <-done)
+	_, open := <-changes
+	assert.False(t, open, "channel must be closed when the watcher stops")
+}
+
+// Kubernetes updates ConfigMap volumes by writing a new timestamped directory,
+// and atomically replacing the ..data symlink to point to it.
+// Returns the volume directory, and a function that updates its contents.
+func configMapVolume(t *testing.T) (string, func()) {
+	volume := t.TempDir()
+	mustSymlink := func(target, link string) {
+		if err := os.Symlink(target, link); err != nil {
+			panic(err)
+		}
+	}
+	mustMkdir := func(path string) {
+		if err := os.Mkdir(path, 0o700); err != nil {
+			panic(err)
+		}
+	}
+	mustMkdir(filepath.Join(volume, "..v1"))
+	write(filepath.Join(volume, "..v1", "ca.pem"), "a")
+	mustSymlink("..v1", filepath.Join(volume, "..data"))
+	mustSymlink("..data/ca.pem", filepath.Join(volume, "ca.pem"))
+
+	return volume, func() {
+		mustMkdir(filepath.Join(volume, "..v2"))
+		write(filepath.Join(volume, "..v2", "ca.pem"), "b")
+		mustSymlink("..v2", filepath.Join(volume, "..data_tmp"))
+		if err := os.Rename(filepath.Join(volume, "..data_tmp"), filepath.Join(volume, "..data")); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestWatchConfigMapVolume(t *testing.T) {
+	for _, name := range []string{"ca.pem", ""} {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		volume, update := configMapVolume(t)
+		changes, _ := watch(t, ctx, filepath.Join(volume, name))
+		update()
+		receive(t, changes)
+
+		cancel()
+	}
+}
+
+func TestWatchDirectory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	directory := t.TempDir()
+	changes, _ := watch(t, ctx, directory)
+
+	write(filepath.Join(directory, "new.pem"), "a")
+	receive(t, changes)
+}
+
+func TestWatchRecreatedDirectory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	directory := filepath.Join(t.TempDir(), "certs")
+	err := os.Mkdir(directory, 0o700)
+	assert.NoError(t, err)
+	changes, _ := watch(t, ctx, directory)
+
+	err = os.RemoveAll(directory)
+	assert.NoError(t, err)
+	receive(t, changes)
+
+	err = os.Mkdir(directory, 0o700)
+	assert.NoError(t, err)
+	receive(t, changes)
+
+	write(filepath.Join(directory, "ca.pem"), "a")
+	receive(t, changes)
+}
+
+func TestWatchTree(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	root := t.TempDir()
+	existing := filepath.Join(root, "existing")
+	err := os.Mkdir(existing, 0o700)
+	assert.NoError(t, err)
+
+	changes := make(chan struct{})
+	go func() {
+		_ = fswatch.WatchTrees(ctx, nil, []string{root}, debounce, changes)
+	}()
+	time.Sleep(debounce)
+
+	write(filepath.Join(existing, "ca.pem"), "a")
+	receive(t, changes)
+
+	created := filepath.Join(root, "created", "nested")
+	err = os.MkdirAll(created, 0o700)
+	assert.NoError(t, err)
+	receive(t, changes)
+
+	// Directories created while watching are watched as well, including their subdirectories.
+	write(filepath.Join(created, "ca.pem"), "b")
+	receive(t, changes)
+}
@@ -0,0 +1,10 @@
+package health
+
+import (
+	"time"
+)
+
+// SetClock replaces the health tracker's clock.
+func (h *Health) SetClock(now func() time.Time) {
+	h.now = now
+}
@@ -0,0 +1,141 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Health tracks the state of certificator's background loops, and serves liveness and readiness probes.
+type Health struct {
+	lock             sync.Mutex
+	refreshStaleness time.Duration
+	loopTimeout      time.Duration
+	lastRefresh      time.Time
+	lastHeartbeat    time.Time
+	watching         bool
+	now              func() time.Time
+}
+
+// Check is the outcome of a single health check.
+type Check struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// New creates a health tracker. The process is considered unready when the bundle hasn't been refreshed
+// within refreshStaleness, and dead when the main loop hasn't reported in within loopTimeout.
+func New(refreshStaleness, loopTimeout time.Duration) *Health {
+	return &Health{
+		refreshStaleness: refreshStaleness,
+		loopTimeout:      loopTimeout,
+		now:              time.Now,
+	}
+}
+
+// Refreshed records that the certificate bundle was loaded or refreshed successfully.
+func (h *Health) Refreshed() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.lastRefresh = h.now()
+}
+
+// Heartbeat records that the main loop, which runs the apply operations, is still responsive.
+func (h *Health) Heartbeat() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.lastHeartbeat = h.now()
+}
+
+// SetWatching records whether the namespace watcher is running.
+func (h *Health) SetWatching(watching bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.watching = watching
+}
+
+func (h *Health) age(t time.Time) time.Duration {
+	return h.now().Sub(t)
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Second)
+}
+
+func (h *Health) loopCheck() Check {
+	switch {
+	case h.lastHeartbeat.IsZero():
+		return Check{OK: true, Message: "starting"}
+	case h.age(h.lastHeartbeat) > h.loopTimeout:
+		return Check{OK: false, Message: fmt.Sprintf("unresponsive for %s", round(h.age(h.lastHeartbeat)))}
+	default:
+		return Check{OK: true, Message: "running"}
+	}
+}
+
+func (h *Health) watcherCheck() Check {
+	if !h.watching {
+		return Check{OK: false, Message: "not running"}
+	}
+	return Check{OK: true, Message: "running"}
+}
+
+func (h *Health) bundleCheck() Check {
+	switch {
+	case h.lastRefresh.IsZero():
+		return Check{OK: false, Message: "not loaded"}
+	case h.age(h.lastRefresh) > h.refreshStaleness:
+		return Check{OK: false, Message: fmt.Sprintf("last refreshed %s ago, more than %s", round(h.age(h.lastRefresh)), h.refreshStaleness)}
+	default:
+		return Check{OK: true, Message: fmt.Sprintf("last refreshed %s ago", round(h.age(h.lastRefresh)))}
+	}
+}
+
+// Liveness reports whether the process is working at all, i.e. whether it should be restarted.
+func (h *Health) Liveness() map[string]Check {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return map[string]Check{
+		"loop": h.loopCheck(),
+	}
+}
+
+// Readiness reports whether the process has a current bundle, and keeps track of namespaces.
+func (h *Health) Readiness() map[string]Check {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return map[string]Check{
+		"loop":    h.loopCheck(),
+		"watcher": h.watcherCheck(),
+		"bundle":  h.bundleCheck(),
+	}
+}
+
+// Serve the outcome of a set of checks; the status code is 200 if all checks pass, or 503 otherwise.
+func serve(w http.ResponseWriter, checks map[string]Check) {
+	status := http.StatusOK
+	for _, check := range checks {
+		if !check.OK {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(checks)
+}
+
+// LivenessHandler serves the liveness probe.
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		serve(w, h.Liveness())
+	})
+}
+
+// ReadinessHandler serves the readiness probe.
+func (h *Health) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		serve(w, h.Readiness())
+	})
+}
@@ -0,0 +1,84 @@
+package health_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nais/certificator/pkg/health"
+	"github.com/stretchr/testify/assert"
+)
+
+func probe(t *testing.T, handler http.Handler) (int, map[string]health.Check) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+
+	checks := make(map[string]health.Check)
+	err := json.NewDecoder(recorder.Body).Decode(&checks)
+	assert.NoError(t, err)
+
+	return recorder.Code, checks
+}
+
+// Make a health tracker with a clock that only advances when told to.
+func withClock(h *health.Health) func(time.Duration) {
+	now := time.Now()
+	h.SetClock(func() time.Time {
+		return now
+	})
+	return func(d time.Duration) {
+		now = now.Add(d)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	h := health.New(time.Minute, time.Hour)
+	advance := withClock(h)
+
+	code, checks := probe(t, h.ReadinessHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, checks["bundle"].OK)
+	assert.False(t, checks["watcher"].OK)
+
+	h.Refreshed()
+	h.SetWatching(true)
+
+	code, checks = probe(t, h.ReadinessHandler())
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, checks["bundle"].OK)
+	assert.True(t, checks["watcher"].OK)
+
+	advance(time.Minute + time.Second)
+
+	code, checks = probe(t, h.ReadinessHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, checks["bundle"].OK, "stale bundle must fail readiness")
+
+	h.Refreshed()
+	h.SetWatching(false)
+
+	code, checks = probe(t, h.ReadinessHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, checks["watcher"].OK)
+}
+
+func TestLiveness(t *testing.T) {
+	h := health.New(time.Hour, time.Minute)
+	advance := withClock(h)
+
+	code, _ := probe(t, h.LivenessHandler())
+	assert.Equal(t, http.StatusOK, code, "must be alive while starting up")
+
+	h.Heartbeat()
+
+	code, _ = probe(t, h.LivenessHandler())
+	assert.Equal(t, http.StatusOK, code)
+
+	advance(time.Minute + time.Second)
+
+	code, checks := probe(t, h.LivenessHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, checks["loop"].OK)
+}
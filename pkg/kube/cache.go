@@ -0,0 +1,151 @@
+package kube
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listerscorev1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/nais/certificator/pkg/resource"
+)
+
+// Label selector matching every resource written by certificator.
+const managedBySelector = managedByLabel + "=" + FieldManager
+
+// Cache keeps a local copy of the ConfigMaps managed by certificator in all namespaces,
+// so that it can be determined whether a resource needs to be written without asking the API server.
+// Secrets are not cached, since that would require reading every Secret in the cluster.
+// They are looked up by name in their namespace instead.
+type Cache struct {
+	client     kubernetes.Interface
+	factory    informers.SharedInformerFactory
+	configMaps listerscorev1.ConfigMapLister
+	informers  []cache.SharedIndexInformer
+}
+
+// NewCache creates a cache for the given resource kinds. Call Start to begin populating it.
+func NewCache(client kubernetes.Interface, kinds []resource.Kind, resync time.Duration) *Cache {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, resync,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = managedBySelector
+		}),
+	)
+	c := &Cache{
+		client:  client,
+		factory: factory,
+	}
+	if slices.Contains(kinds, resource.KindConfigMap) {
+		informer := factory.Core().V1().ConfigMaps()
+		c.configMaps = informer.Lister()
+		c.informers = append(c.informers, informer.Informer())
+	}
+	return c
+}
+
+// Start populating the cache in the background, until the context is canceled.
+func (c *Cache) Start(ctx context.Context) {
+	c.factory.Start(ctx.Done())
+}
+
+// WaitForSync blocks until the cache has been populated, or the context is canceled.
+// Returns true if the cache is populated.
+func (c *Cache) WaitForSync(ctx context.Context) bool {
+	synced := make([]cache.InformerSynced, len(c.informers))
+	for i, informer := range c.informers {
+		synced[i] = informer.HasSynced
+	}
+	return cache.WaitForCacheSync(ctx.Done(), synced...)
+}
+
+// WatchDrift sends the namespace of every managed ConfigMap that is deleted,
+// or whose content no longer matches what certificator wrote into it.
+// Drifted Secrets are found when the bundle is next applied.
+func (c *Cache) WatchDrift(ctx context.Context, namespaces chan<- string) error {
+	send := func(meta metav1.ObjectMeta, reason string) {
+		log.Debugf("%s/%s %s", meta.Namespace, meta.Name, reason)
+		select {
+		case <-ctx.Done():
+		case namespaces <- meta.Namespace:
+		}
+	}
+	check := func(obj any) {
+		meta, data, ok := contents(obj)
+		if ok && drifted(meta, data) {
+			send(meta, "changed by someone else")
+		}
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: check,
+		UpdateFunc: func(_, obj any) {
+			check(obj)
+		},
+		DeleteFunc: func(obj any) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			meta, _, ok := contents(obj)
+			if ok && resourceFilename(meta.Name) != "" {
+				send(meta, "deleted")
+			}
+		},
+	}
+	for _, informer := range c.informers {
+		_, err := informer.AddEventHandler(handler)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check if a resource already holds a bundle with the given hash, protected by a password the given function accepts.
+func (c *Cache) current(ctx context.Context, kind, namespace, name, hash string, password func(passwordHash string) bool) bool {
+	if c == nil {
+		return false
+	}
+	var obj any
+	var err error
+	switch {
+	case kind == configMapKind && c.configMaps != nil:
+		obj, err = c.configMaps.ConfigMaps(namespace).Get(name)
+	case kind == secretKind:
+		obj, err = c.client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	default:
+		return false
+	}
+	if err != nil {
+		return false
+	}
+	meta, data, _ := contents(obj)
+	return !drifted(meta, data) && meta.Annotations[bundleHashAnnotation] == hash && password(meta.Annotations[passwordHashAnnotation])
+}
+
+// Extract metadata and data from a ConfigMap or Secret.
+func contents(obj any) (metav1.ObjectMeta, map[string][]byte, bool) {
+	switch resource := obj.(type) {
+	case *v1.ConfigMap:
+		return resource.ObjectMeta, resource.BinaryData, true
+	case *v1.Secret:
+		return resource.ObjectMeta, resource.Data, true
+	default:
+		return metav1.ObjectMeta{}, nil, false
+	}
+}
+
+// Check if a resource's content differs from what certificator wrote into it.
+// Resources with names certificator doesn't write are never considered drifted.
+func drifted(meta metav1.ObjectMeta, data map[string][]byte) bool {
+	filename := resourceFilename(meta.Name)
+	if filename == "" {
+		return false
+	}
+	content := data[filename]
+	return len(content) == 0 || meta.Annotations[contentHashAnnotation] != contentHash(content)
+}
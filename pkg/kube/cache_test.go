@@ -0,0 +1,92 @@
+package kube_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nais/certificator/pkg/kube"
+	"github.com/nais/certificator/pkg/resource"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWatchDrift(t *testing.T) {
+	const namespace = "team"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kinds := []resource.Kind{resource.KindConfigMap, resource.KindSecret}
+	client := fake.NewClientset()
+	cache := kube.NewCache(client, kinds, 0)
+	drifts := make(chan string, 16)
+	err := cache.WatchDrift(ctx, drifts)
+	assert.NoError(t, err)
+	cache.Start(ctx)
+	assert.True(t, cache.WaitForSync(ctx))
+
+	errs := applyAll(t, client, kube.Options{Kinds: kinds, Cache: cache}, namespace)
+	assert.Empty(t, errs)
+
+	select {
+	case name := <-drifts:
+		t.Fatalf("resources written by certificator reported as drifted in namespace %q", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(ctx, "ca-bundle-pem", metav1.GetOptions{})
+	assert.NoError(t, err)
+	cm.BinaryData["ca-bundle.pem"] = []byte("garbage")
+	_, err = client.CoreV1().ConfigMaps(namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	assert.Equal(t, namespace, receiveDrift(t, drifts))
+
+	// Secrets are not watched, but looked up when the bundle is applied.
+	err = client.CoreV1().Secrets(namespace).Delete(ctx, "ca-bundle-jks", metav1.DeleteOptions{})
+	assert.NoError(t, err)
+
+	client.ClearActions()
+	errs = applyAll(t, client, kube.Options{Kinds: kinds, Cache: cache, ForceConflicts: true}, namespace)
+	assert.Empty(t, errs)
+	assert.Equal(t, 2, countActions(client, "patch"), "only drifted resources must be written")
+}
+
+func TestCacheReadsSecretsByName(t *testing.T) {
+	const namespace = "team"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kinds := []resource.Kind{resource.KindSecret}
+	client := fake.NewClientset()
+	cache := kube.NewCache(client, kinds, 0)
+	cache.Start(ctx)
+	assert.True(t, cache.WaitForSync(ctx))
+
+	errs := applyAll(t, client, kube.Options{Kinds: kinds, Cache: cache}, namespace)
+	assert.Empty(t, errs)
+	assert.Equal(t, 3, countActions(client, "patch"))
+
+	errs = applyAll(t, client, kube.Options{Kinds: kinds, Cache: cache}, namespace)
+	assert.Empty(t, errs)
+	assert.Equal(t, 3, countActions(client, "patch"), "secrets holding the current bundle must not be written")
+
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "secrets" {
+			assert.Contains(t, []string{"get", "patch"}, action.GetVerb(), "secrets must only be read by name")
+		}
+	}
+}
+
+func receiveDrift(t *testing.T, drifts <-chan string) string {
+	select {
+	case name := <-drifts:
+		return name
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for drift")
+		return ""
+	}
+}
@@ -3,19 +3,26 @@ package kube
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
 	"k8s.io/client-go/kubernetes"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/nais/certificator/pkg/metrics"
+	"github.com/nais/certificator/pkg/resource"
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth/azure"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
@@ -25,12 +32,34 @@ import (
 const (
 	pemFilename = "ca-bundle.pem"
 	jksFilename = "ca-bundle.jks"
+	p12Filename = "ca-bundle.p12"
+)
+
+// FieldManager identifies certificator as the owner of the fields it applies.
+const FieldManager = "certificator"
+
+// Kubernetes metadata written to every resource.
+// The last-applied time is when certificator last wrote the resource, which it only does
+// when the resource doesn't hold the current bundle.
+const (
+	managedByLabel         = "app.kubernetes.io/managed-by"
+	lastAppliedAnnotation  = "certificator.nais.io/last-applied-at"
+	bundleHashAnnotation   = "certificator.nais.io/bundle-hash"
+	passwordHashAnnotation = "certificator.nais.io/password-hash"
+	contentHashAnnotation  = "certificator.nais.io/content-hash"
+)
+
+// Kubernetes resource kinds, as written in logs
+const (
+	configMapKind = "ConfigMap"
+	secretKind    = "Secret"
 )
 
 // Kubernetes CM names
 const (
 	pemResourceName = "ca-bundle-pem"
 	jksResourceName = "ca-bundle-jks"
+	p12ResourceName = "ca-bundle-p12"
 )
 
 type PEMWriter interface {
@@ -41,9 +70,107 @@ type JKSWriter interface {
 	WriteJKS(w io.Writer) error
 }
 
+type PKCS12Writer interface {
+	WritePKCS12(w io.Writer) error
+}
+
+type Hasher interface {
+	Hash() []byte
+}
+
+// PasswordHasher digests the keystore password with a salt, so that password changes can be detected.
+type PasswordHasher interface {
+	PasswordHash(salt []byte) ([]byte, error)
+}
+
 type BundleWriter interface {
+	Hasher
+	PasswordHasher
 	JKSWriter
 	PEMWriter
+	PKCS12Writer
+}
+
+// Formats the bundle is published in, one resource per format.
+var formats = []struct {
+	filename     string
+	resourceName string
+	write        func(BundleWriter, io.Writer) error
+}{
+	{pemFilename, pemResourceName, BundleWriter.WritePEM},
+	{jksFilename, jksResourceName, BundleWriter.WriteJKS},
+	{p12Filename, p12ResourceName, BundleWriter.WritePKCS12},
+}
+
+// Return the data key used in a resource with the given name, or an empty string if it is not one of ours.
+func resourceFilename(resourceName string) string {
+	for _, format := range formats {
+		if format.resourceName == resourceName {
+			return format.filename
+		}
+	}
+	return ""
+}
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func objectMeta(resourceName string, data []byte) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name: resourceName,
+		Labels: map[string]string{
+			managedByLabel: FieldManager,
+		},
+		Annotations: map[string]string{
+			lastAppliedAnnotation: time.Now().Format(time.RFC3339),
+			contentHashAnnotation: contentHash(data),
+		},
+	}
+}
+
+// Hash the keystore password with a new random salt, formatted as salt and digest in hex.
+func newPasswordHash(bundle PasswordHasher) (string, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	digest, err := bundle.PasswordHash(salt)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(salt) + ":" + hex.EncodeToString(digest), nil
+}
+
+// Checks password hashes found on resources against the keystore password.
+// Hashing is slow on purpose, so the result is remembered for each hash.
+type passwordChecker struct {
+	bundle  PasswordHasher
+	lock    sync.Mutex
+	checked map[string]bool
+}
+
+func (checker *passwordChecker) matches(passwordHash string) bool {
+	checker.lock.Lock()
+	defer checker.lock.Unlock()
+	if matches, ok := checker.checked[passwordHash]; ok {
+		return matches
+	}
+	if checker.checked == nil {
+		checker.checked = make(map[string]bool)
+	}
+	matches := false
+	saltHex, digestHex, ok := strings.Cut(passwordHash, ":")
+	salt, saltErr := hex.DecodeString(saltHex)
+	digest, digestErr := hex.DecodeString(digestHex)
+	if ok && saltErr == nil && digestErr == nil {
+		expected, err := checker.bundle.PasswordHash(salt)
+		matches = err == nil && hmac.Equal(digest, expected)
+	}
+	checker.checked[passwordHash] = matches
+	return matches
 }
 
 func configMap(filename, resourceName string, writer func(io.Writer) error) (*v1.ConfigMap, error) {
@@ -53,21 +180,28 @@ func configMap(filename, resourceName string, writer func(io.Writer) error) (*v1
 		return nil, err
 	}
 	return &v1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: resourceName,
-			Labels: map[string]string{
-				"app.kubernetes.io/managed-by": "certificator",
-			},
-			Annotations: map[string]string{
-				"certificator.nais.io/last-applied-at": time.Now().Format(time.RFC3339),
-			},
-		},
+		ObjectMeta: objectMeta(resourceName, raw.Bytes()),
 		BinaryData: map[string][]byte{
 			filename: raw.Bytes(),
 		},
 	}, nil
 }
 
+func secret(filename, resourceName string, writer func(io.Writer) error) (*v1.Secret, error) {
+	raw := &bytes.Buffer{}
+	err := writer(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.Secret{
+		ObjectMeta: objectMeta(resourceName, raw.Bytes()),
+		Type:       v1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			filename: raw.Bytes(),
+		},
+	}, nil
+}
+
 func ConfigMapPEM(bundle PEMWriter) (*v1.ConfigMap, error) {
 	return configMap(pemFilename, pemResourceName, bundle.WritePEM)
 }
@@ -76,6 +210,22 @@ func ConfigMapJKS(bundle JKSWriter) (*v1.ConfigMap, error) {
 	return configMap(jksFilename, jksResourceName, bundle.WriteJKS)
 }
 
+func ConfigMapPKCS12(bundle PKCS12Writer) (*v1.ConfigMap, error) {
+	return configMap(p12Filename, p12ResourceName, bundle.WritePKCS12)
+}
+
+func SecretPEM(bundle PEMWriter) (*v1.Secret, error) {
+	return secret(pemFilename, pemResourceName, bundle.WritePEM)
+}
+
+func SecretJKS(bundle JKSWriter) (*v1.Secret, error) {
+	return secret(jksFilename, jksResourceName, bundle.WriteJKS)
+}
+
+func SecretPKCS12(bundle PKCS12Writer) (*v1.Secret, error) {
+	return secret(p12Filename, p12ResourceName, bundle.WritePKCS12)
+}
+
 func Client() (*kubernetes.Clientset, error) {
 	rules := clientcmd.NewDefaultClientConfigLoadingRules()
 	cfg := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, nil)
@@ -86,39 +236,139 @@ func Client() (*kubernetes.Clientset, error) {
 	return kubernetes.NewForConfig(rest)
 }
 
-func createOrUpdate(ctx context.Context, client corev1.ConfigMapInterface, resource *v1.ConfigMap) error {
-	_, err := client.Create(ctx, resource, metav1.CreateOptions{})
-	if errors.IsAlreadyExists(err) {
-		_, err = client.Update(ctx, resource, metav1.UpdateOptions{})
+// Options control how the bundle is published into namespaces.
+type Options struct {
+	// Kinds of resources to publish the bundle as.
+	Kinds []resource.Kind
+	// ForceConflicts takes ownership of fields that are managed by someone else.
+	// Without it, such conflicts fail the apply.
+	ForceConflicts bool
+	// Cache of existing resources, used to skip writing resources that already hold the bundle.
+	// If nil, every resource is written.
+	Cache *Cache
+}
+
+func applyOptions(opts Options) metav1.ApplyOptions {
+	return metav1.ApplyOptions{
+		FieldManager: FieldManager,
+		Force:        opts.ForceConflicts,
 	}
+}
+
+func applyConfigMap(ctx context.Context, client corev1.ConfigMapInterface, namespace string, resource *v1.ConfigMap, opts Options) error {
+	cfg := corev1ac.ConfigMap(resource.Name, namespace).
+		WithLabels(resource.Labels).
+		WithAnnotations(resource.Annotations).
+		WithBinaryData(resource.BinaryData)
+	_, err := client.Apply(ctx, cfg, applyOptions(opts))
+	return err
+}
+
+func applySecret(ctx context.Context, client corev1.SecretInterface, namespace string, resource *v1.Secret, opts Options) error {
+	cfg := corev1ac.Secret(resource.Name, namespace).
+		WithLabels(resource.Labels).
+		WithAnnotations(resource.Annotations).
+		WithType(resource.Type).
+		WithData(resource.Data)
+	_, err := client.Apply(ctx, cfg, applyOptions(opts))
 	return err
 }
 
-func GenerateApplyOperations(ctx context.Context, client *kubernetes.Clientset, bundle BundleWriter, namespaces Namespaces, applies chan func() error) error {
-	jks, err := ConfigMapJKS(bundle)
+// An operation writes a single resource into a namespace.
+type operation struct {
+	kind  string
+	name  string
+	apply func(namespace string) error
+}
+
+func (op operation) String() string {
+	return fmt.Sprintf("%s %q", op.kind, op.name)
+}
+
+// Generate the operations needed to publish the bundle as the given resource kinds.
+// Every resource is stamped with the bundle hash and a salted hash of the keystore password.
+func operations(ctx context.Context, client kubernetes.Interface, bundle BundleWriter, opts Options) ([]operation, error) {
+	ops := make([]operation, 0)
+	hash := hex.EncodeToString(bundle.Hash())
+	passwordHash, err := newPasswordHash(bundle)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	pem, err := ConfigMapPEM(bundle)
+	for _, kind := range opts.Kinds {
+		for _, format := range formats {
+			op := operation{
+				name: format.resourceName,
+			}
+			writer := func(w io.Writer) error {
+				return format.write(bundle, w)
+			}
+
+			switch kind {
+			case resource.KindConfigMap:
+				cm, err := configMap(format.filename, format.resourceName, writer)
+				if err != nil {
+					return nil, err
+				}
+				cm.Annotations[bundleHashAnnotation] = hash
+				cm.Annotations[passwordHashAnnotation] = passwordHash
+				op.kind = configMapKind
+				op.apply = func(namespace string) error {
+					return applyConfigMap(ctx, client.CoreV1().ConfigMaps(namespace), namespace, cm, opts)
+				}
+
+			case resource.KindSecret:
+				sec, err := secret(format.filename, format.resourceName, writer)
+				if err != nil {
+					return nil, err
+				}
+				sec.Annotations[bundleHashAnnotation] = hash
+				sec.Annotations[passwordHashAnnotation] = passwordHash
+				op.kind = secretKind
+				op.apply = func(namespace string) error {
+					return applySecret(ctx, client.CoreV1().Secrets(namespace), namespace, sec, opts)
+				}
+
+			default:
+				return nil, fmt.Errorf("unsupported resource kind %q", kind)
+			}
+
+			ops = append(ops, op)
+		}
+	}
+
+	return ops, nil
+}
+
+func GenerateApplyOperations(ctx context.Context, client kubernetes.Interface, bundle BundleWriter, opts Options, namespaces Namespaces, applies chan func() error) error {
+	ops, err := operations(ctx, client, bundle, opts)
 	if err != nil {
 		return err
 	}
+	hash := hex.EncodeToString(bundle.Hash())
+	password := &passwordChecker{bundle: bundle}
 
-	apply := func(ns *Namespace, cmaps ...*v1.ConfigMap) error {
-		nsclient := client.CoreV1().ConfigMaps(ns.Name)
-		for _, cm := range cmaps {
-			er := createOrUpdate(ctx, nsclient, cm)
+	apply := func(ns *Namespace) error {
+		for _, op := range ops {
+			if opts.Cache.current(ctx, op.kind, ns.Name, op.name, hash, password.matches) {
+				log.Debugf("%s in namespace %q already holds the current bundle", op, ns.Name)
+				metrics.IncSyncSkipped()
+				continue
+			}
+			er := op.apply(ns.Name)
 			if er == nil {
-				log.Debugf("Applied %q to namespace %q", cm.Name, ns.Name)
+				log.Debugf("Applied %s to namespace %q", op, ns.Name)
 				metrics.IncSync(0)
 			} else {
+				err := fmt.Errorf("apply %s to namespace %q: %s", op, ns.Name, er)
 				ns.LastFailure = time.Now()
+				ns.LastError = err.Error()
 				metrics.IncSync(1)
-				return fmt.Errorf("apply %q to namespace %q: %s", cm.Name, ns.Name, er)
+				return err
 			}
 		}
 		ns.LastSuccess = time.Now()
+		ns.LastError = ""
 		return nil
 	}
 
@@ -127,7 +377,7 @@ func GenerateApplyOperations(ctx context.Context, client *kubernetes.Clientset,
 		for _, namespace := range namespaces {
 			ns := namespace
 			applies <- func() error {
-				return apply(ns, pem, jks)
+				return apply(ns)
 			}
 		}
 	}()
@@ -1,17 +1,25 @@
 package kube_test
 
 import (
+	"context"
+	"encoding/hex"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/nais/certificator/pkg/certbundle"
 	"github.com/nais/certificator/pkg/kube"
+	"github.com/nais/certificator/pkg/resource"
 	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 const password = "foobar"
 
-func bundleFromTestData() *certbundle.Bundle {
+func bundleFromTestData(jksPassword string) *certbundle.Bundle {
 	f, err := os.Open("../../testdata/cacert.pem")
 	if err != nil {
 		panic(err)
@@ -22,7 +30,7 @@ func bundleFromTestData() *certbundle.Bundle {
 		}
 	}()
 
-	bundle := certbundle.New(password)
+	bundle := certbundle.New(jksPassword)
 
 	err = bundle.ReadAll(f)
 	if err != nil {
@@ -33,7 +41,7 @@ func bundleFromTestData() *certbundle.Bundle {
 }
 
 func TestConfigMapPEM(t *testing.T) {
-	bundle := bundleFromTestData()
+	bundle := bundleFromTestData(password)
 
 	cm, err := kube.ConfigMapPEM(bundle)
 	if err != nil {
@@ -44,7 +52,7 @@ func TestConfigMapPEM(t *testing.T) {
 }
 
 func TestConfigMapJKS(t *testing.T) {
-	bundle := bundleFromTestData()
+	bundle := bundleFromTestData(password)
 
 	cm, err := kube.ConfigMapJKS(bundle)
 	if err != nil {
@@ -53,3 +61,200 @@ func TestConfigMapJKS(t *testing.T) {
 
 	assert.NotEmpty(t, cm.BinaryData)
 }
+
+func TestConfigMapPKCS12(t *testing.T) {
+	bundle := bundleFromTestData(password)
+
+	cm, err := kube.ConfigMapPKCS12(bundle)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.NotEmpty(t, cm.BinaryData)
+}
+
+func TestSecrets(t *testing.T) {
+	bundle := bundleFromTestData(password)
+
+	for _, generate := range []func(*certbundle.Bundle) (*v1.Secret, error){
+		func(b *certbundle.Bundle) (*v1.Secret, error) { return kube.SecretPEM(b) },
+		func(b *certbundle.Bundle) (*v1.Secret, error) { return kube.SecretJKS(b) },
+		func(b *certbundle.Bundle) (*v1.Secret, error) { return kube.SecretPKCS12(b) },
+	} {
+		secret, err := generate(bundle)
+		if err != nil {
+			panic(err)
+		}
+
+		assert.Equal(t, v1.SecretTypeOpaque, secret.Type)
+		assert.Len(t, secret.Data, 1)
+		assert.Equal(t, "certificator", secret.Labels["app.kubernetes.io/managed-by"])
+	}
+}
+
+// Generate apply operations for the given namespaces, and run them all.
+func applyAll(t *testing.T, client *fake.Clientset, opts kube.Options, names ...string) []error {
+	return applyBundle(t, client, bundleFromTestData(password), opts, names...)
+}
+
+// Generate apply operations for a bundle in the given namespaces, and run them all.
+func applyBundle(t *testing.T, client *fake.Clientset, bundle *certbundle.Bundle, opts kube.Options, names ...string) []error {
+	namespaces := make(kube.Namespaces)
+	for _, name := range names {
+		namespaces[name] = &kube.Namespace{Name: name}
+	}
+
+	applies := make(chan func() error, len(names))
+	err := kube.GenerateApplyOperations(context.Background(), client, bundle, opts, namespaces, applies)
+	assert.NoError(t, err)
+
+	errs := make([]error, 0)
+	for range names {
+		apply := <-applies
+		if err := apply(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func TestApplyKeepsForeignFields(t *testing.T) {
+	const namespace = "team"
+
+	client := fake.NewClientset()
+	_, err := client.CoreV1().ConfigMaps(namespace).Create(context.Background(), &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "ca-bundle-pem",
+			Namespace:   namespace,
+			Labels:      map[string]string{"team": "foo"},
+			Annotations: map[string]string{"example.com/owner": "foo"},
+		},
+		Data: map[string]string{"README": "managed by certificator"},
+	}, metav1.CreateOptions{FieldManager: "kubectl"})
+	assert.NoError(t, err)
+
+	errs := applyAll(t, client, kube.Options{
+		Kinds: []resource.Kind{resource.KindConfigMap, resource.KindSecret},
+	}, namespace)
+	assert.Empty(t, errs)
+
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(context.Background(), "ca-bundle-pem", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", cm.Labels["team"])
+	assert.Equal(t, "certificator", cm.Labels["app.kubernetes.io/managed-by"])
+	assert.Equal(t, "foo", cm.Annotations["example.com/owner"])
+	assert.Equal(t, "managed by certificator", cm.Data["README"])
+	assert.NotEmpty(t, cm.BinaryData["ca-bundle.pem"])
+
+	secret, err := client.CoreV1().Secrets(namespace).Get(context.Background(), "ca-bundle-p12", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, secret.Data["ca-bundle.p12"])
+}
+
+func TestApplyConflicts(t *testing.T) {
+	const namespace = "team"
+
+	client := fake.NewClientset()
+	_, err := client.CoreV1().ConfigMaps(namespace).Create(context.Background(), &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ca-bundle-pem",
+			Namespace: namespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "someone-else"},
+		},
+	}, metav1.CreateOptions{FieldManager: "kubectl"})
+	assert.NoError(t, err)
+
+	errs := applyAll(t, client, kube.Options{
+		Kinds: []resource.Kind{resource.KindConfigMap},
+	}, namespace)
+	assert.Len(t, errs, 1)
+
+	errs = applyAll(t, client, kube.Options{
+		Kinds:          []resource.Kind{resource.KindConfigMap},
+		ForceConflicts: true,
+	}, namespace)
+	assert.Empty(t, errs)
+
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(context.Background(), "ca-bundle-pem", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "certificator", cm.Labels["app.kubernetes.io/managed-by"])
+}
+
+func countActions(client *fake.Clientset, verb string) int {
+	n := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == verb {
+			n++
+		}
+	}
+	return n
+}
+
+func TestApplySkipsCurrentResources(t *testing.T) {
+	const namespace = "team"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kinds := []resource.Kind{resource.KindConfigMap}
+	client := fake.NewClientset()
+	cache := kube.NewCache(client, kinds, 0)
+	cache.Start(ctx)
+	assert.True(t, cache.WaitForSync(ctx))
+
+	errs := applyAll(t, client, kube.Options{Kinds: kinds, Cache: cache}, namespace)
+	assert.Empty(t, errs)
+	assert.Equal(t, 3, countActions(client, "patch"))
+
+	assert.Eventually(t, func() bool {
+		client.ClearActions()
+		errs = applyAll(t, client, kube.Options{Kinds: kinds, Cache: cache}, namespace)
+		return len(errs) == 0 && countActions(client, "patch") == 0
+	}, 5*time.Second, 10*time.Millisecond, "resources holding the current bundle must not be written")
+
+	err := client.CoreV1().ConfigMaps(namespace).Delete(ctx, "ca-bundle-jks", metav1.DeleteOptions{})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		client.ClearActions()
+		errs = applyAll(t, client, kube.Options{Kinds: kinds, Cache: cache}, namespace)
+		return len(errs) == 0 && countActions(client, "patch") == 1
+	}, 5*time.Second, 10*time.Millisecond, "deleted resources must be written again")
+
+	assert.Eventually(t, func() bool {
+		client.ClearActions()
+		errs = applyAll(t, client, kube.Options{Kinds: kinds, Cache: cache}, namespace)
+		return len(errs) == 0 && countActions(client, "patch") == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	client.ClearActions()
+	errs = applyBundle(t, client, bundleFromTestData("changed"), kube.Options{Kinds: kinds, Cache: cache}, namespace)
+	assert.Empty(t, errs)
+	assert.Equal(t, 3, countActions(client, "patch"), "resources must be written again when the keystore password changes")
+}
+
+func TestApplyAnnotations(t *testing.T) {
+	const namespace = "team"
+
+	client := fake.NewClientset()
+	bundle := bundleFromTestData(password)
+	errs := applyBundle(t, client, bundle, kube.Options{Kinds: []resource.Kind{resource.KindConfigMap}}, namespace)
+	assert.Empty(t, errs)
+
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(context.Background(), "ca-bundle-jks", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(bundle.Hash()), cm.Annotations["certificator.nais.io/bundle-hash"])
+
+	// The password is only published as a salted, slow hash.
+	saltHex, digestHex, ok := strings.Cut(cm.Annotations["certificator.nais.io/password-hash"], ":")
+	assert.True(t, ok)
+	salt, err := hex.DecodeString(saltHex)
+	assert.NoError(t, err)
+	assert.Len(t, salt, 16)
+	expected, err := bundle.PasswordHash(salt)
+	assert.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(expected), digestHex)
+	other, err := bundleFromTestData("changed").PasswordHash(salt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hex.EncodeToString(other), digestHex)
+}
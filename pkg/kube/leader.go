@@ -0,0 +1,84 @@
+package kube
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// LeaderElection configures the Lease used to elect a single instance that writes CA bundles.
+type LeaderElection struct {
+	LeaseName      string
+	LeaseNamespace string
+	Identity       string
+	LeaseDuration  time.Duration
+	RenewDeadline  time.Duration
+	RetryPeriod    time.Duration
+}
+
+// Elect campaigns for leadership until the context is canceled. The channel receives true when
+// this instance becomes the leader, and false when it loses leadership. After losing leadership,
+// the instance keeps campaigning. Blocks until the context is canceled.
+func Elect(ctx context.Context, client kubernetes.Interface, cfg LeaderElection, leading chan<- bool) error {
+	var leader atomic.Bool
+	send := func(value bool) {
+		select {
+		case <-ctx.Done():
+		case leading <- value:
+		}
+	}
+
+	config := leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Name:      cfg.LeaseName,
+				Namespace: cfg.LeaseNamespace,
+			},
+			Client: client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity: cfg.Identity,
+			},
+		},
+		LeaseDuration:   cfg.LeaseDuration,
+		RenewDeadline:   cfg.RenewDeadline,
+		RetryPeriod:     cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            cfg.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				log.Infof("Acquired leadership of lease %s/%s as %q", cfg.LeaseNamespace, cfg.LeaseName, cfg.Identity)
+				leader.Store(true)
+				send(true)
+			},
+			OnStoppedLeading: func() {
+				// Also called when leadership was never acquired.
+				if !leader.Swap(false) {
+					return
+				}
+				log.Infof("Lost leadership of lease %s/%s", cfg.LeaseNamespace, cfg.LeaseName)
+				send(false)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != cfg.Identity {
+					log.Infof("Instance %q is the leader of lease %s/%s", identity, cfg.LeaseNamespace, cfg.LeaseName)
+				}
+			},
+		},
+	}
+
+	for ctx.Err() == nil {
+		elector, err := leaderelection.NewLeaderElector(config)
+		if err != nil {
+			return err
+		}
+		elector.Run(ctx)
+	}
+
+	return nil
+}
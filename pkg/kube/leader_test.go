@@ -0,0 +1,65 @@
+package kube_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nais/certificator/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestElect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewClientset()
+	cfg := kube.LeaderElection{
+		LeaseName:      "certificator",
+		LeaseNamespace: "nais-system",
+		LeaseDuration:  time.Second,
+		RenewDeadline:  500 * time.Millisecond,
+		RetryPeriod:    100 * time.Millisecond,
+	}
+
+	first := make(chan bool, 1)
+	firstCtx, firstCancel := context.WithCancel(ctx)
+	firstDone := make(chan error)
+	go func() {
+		cfg := cfg
+		cfg.Identity = "first"
+		firstDone <- kube.Elect(firstCtx, client, cfg, first)
+	}()
+
+	select {
+	case leading := <-first:
+		assert.True(t, leading)
+	case <-time.After(5 * time.Second):
+		t.Fatal("first instance never became leader")
+	}
+
+	second := make(chan bool, 1)
+	go func() {
+		cfg := cfg
+		cfg.Identity = "second"
+		_ = kube.Elect(ctx, client, cfg, second)
+	}()
+
+	select {
+	case <-second:
+		t.Fatal("second instance became leader while the first one holds the lease")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// Stopping the leader releases the lease, and the follower takes over.
+	firstCancel()
+	assert.NoError(t, <-firstDone)
+
+	select {
+	case leading := <-second:
+		assert.True(t, leading)
+	case <-time.After(5 * time.Second):
+		t.Fatal("second instance never became leader")
+	}
+}
@@ -7,7 +7,9 @@ import (
 	log "github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 )
 
 type Namespaces map[string]*Namespace
@@ -17,6 +19,7 @@ type Namespace struct {
 	LastSeen    time.Time
 	LastSuccess time.Time
 	LastFailure time.Time
+	LastError   string
 	Deleted     bool
 }
 
@@ -30,31 +33,52 @@ func (namespaces Namespaces) UnsuccessfulSince(t time.Time) Namespaces {
 	return result
 }
 
-func Watch(ctx context.Context, client *kubernetes.Clientset, labelSelector string, namespaces chan<- *Namespace) error {
+// Watch namespaces matching a label selector using a shared informer, and send them to a channel
+// whenever they are added, changed, deleted, or resynchronized.
+// The informer relists after connection failures, so namespaces deleted in the meantime are reported as deleted.
+// Blocks until the context is canceled, then closes the channel.
+func Watch(ctx context.Context, client kubernetes.Interface, labelSelector string, resync time.Duration, namespaces chan<- *Namespace) error {
 	defer close(namespaces)
 
-	watcher, err := client.CoreV1().Namespaces().Watch(ctx, metav1.ListOptions{
-		LabelSelector:   labelSelector,
-		ResourceVersion: "0",
+	informer := coreinformers.NewFilteredNamespaceInformer(client, resync, cache.Indexers{}, func(options *metav1.ListOptions) {
+		options.LabelSelector = labelSelector
 	})
-	if err != nil {
-		return err
-	}
 
-	defer watcher.Stop()
-
-	for event := range watcher.ResultChan() {
-		namespace, ok := event.Object.(*v1.Namespace)
+	send := func(obj any, deleted bool) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		namespace, ok := obj.(*v1.Namespace)
 		if !ok {
-			log.Debugf("watch: skip %T %v", event.Object, event.Object)
-			continue
+			log.Debugf("watch: skip %T %v", obj, obj)
+			return
 		}
-		namespaces <- &Namespace{
+		select {
+		case <-ctx.Done():
+		case namespaces <- &Namespace{
 			Name:     namespace.Name,
 			LastSeen: time.Now(),
-			Deleted:  namespace.DeletionTimestamp != nil,
+			Deleted:  deleted || namespace.DeletionTimestamp != nil,
+		}:
 		}
 	}
 
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) {
+			send(obj, false)
+		},
+		UpdateFunc: func(_, obj any) {
+			send(obj, false)
+		},
+		DeleteFunc: func(obj any) {
+			send(obj, true)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	informer.Run(ctx.Done())
+
 	return nil
 }
@@ -0,0 +1,84 @@
+package kube_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nais/certificator/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func namespace(name string, labels map[string]string) *v1.Namespace {
+	return &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+}
+
+func receive(t *testing.T, namespaces <-chan *kube.Namespace) *kube.Namespace {
+	select {
+	case ns := <-namespaces:
+		return ns
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for namespace")
+		return nil
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewClientset(
+		namespace("team-a", map[string]string{"team": "a"}),
+		namespace("kube-system", nil),
+	)
+
+	namespaces := make(chan *kube.Namespace, 16)
+	done := make(chan error)
+	go func() {
+		done <- kube.Watch(ctx, client, "team", time.Hour, namespaces)
+	}()
+
+	ns := receive(t, namespaces)
+	assert.Equal(t, "team-a", ns.Name)
+	assert.False(t, ns.Deleted)
+
+	_, err := client.CoreV1().Namespaces().Create(ctx, namespace("team-b", map[string]string{"team": "b"}), metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	ns = receive(t, namespaces)
+	assert.Equal(t, "team-b", ns.Name)
+	assert.False(t, ns.Deleted)
+
+	err = client.CoreV1().Namespaces().Delete(ctx, "team-a", metav1.DeleteOptions{})
+	assert.NoError(t, err)
+
+	ns = receive(t, namespaces)
+	assert.Equal(t, "team-a", ns.Name)
+	assert.True(t, ns.Deleted)
+
+	cancel()
+	assert.NoError(t, <-done)
+
+	_, open := <-namespaces
+	assert.False(t, open, "channel must be closed when the watcher stops")
+}
+
+func TestUnsuccessfulSince(t *testing.T) {
+	now := time.Now()
+	namespaces := kube.Namespaces{
+		"old": &kube.Namespace{Name: "old", LastSuccess: now.Add(-time.Hour)},
+		"new": &kube.Namespace{Name: "new", LastSuccess: now.Add(time.Hour)},
+	}
+
+	candidates := namespaces.UnsuccessfulSince(now)
+	assert.Len(t, candidates, 1)
+	assert.Contains(t, candidates, "old")
+}
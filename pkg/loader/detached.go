@@ -0,0 +1,183 @@
+package loader
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/nais/certificator/pkg/metrics"
+)
+
+// ErrSignatureInvalid is returned when downloaded content doesn't match its detached signature.
+var ErrSignatureInvalid = errors.New("invalid signature")
+
+// ReadPublicKey reads a PEM encoded PKIX public key from a file, for verifying signatures.
+func ReadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey, *rsa.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported public key type %T", path, key)
+	}
+}
+
+// Verify a signature made with a private key, as created by one of:
+//
+//	openssl dgst -sha256 -sign key.pem       # ECDSA and RSA (PKCS #1 v1.5), over the SHA-256 digest
+//	openssl pkeyutl -sign -rawin -inkey key.pem  # Ed25519, over the content itself
+func verifySignature(key crypto.PublicKey, content, signature []byte) error {
+	digest := sha256.Sum256(content)
+	var ok bool
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, content, signature)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	if !ok {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+// Decode a signature file, which is either binary or base64 encoded.
+func decodeSignature(data []byte) []byte {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return data
+	}
+	return decoded
+}
+
+// Parse a checksum file in the format written by sha256sum, i.e. the checksum followed by an optional filename.
+func parseChecksum(data []byte) (string, error) {
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("not a SHA-256 checksum file")
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// Download a companion file, such as a checksum or signature.
+func (downloader *Downloader) getCompanion(ctx context.Context, source Source, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	err = source.Auth.apply(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := downloader.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// The detached checksum and signature some content was verified with.
+// They are kept with the content, so that it can be verified again when reused without downloading them.
+type detached struct {
+	checksumURL  string
+	checksum     string
+	signatureURL string
+	signature    []byte
+}
+
+// Verify content against a previously downloaded checksum and signature.
+// Fails if the source expects a checksum or signature the content wasn't verified with.
+func (d detached) verify(source Source, body []byte) error {
+	if source.ChecksumURL != "" {
+		if d.checksumURL != source.ChecksumURL {
+			return fmt.Errorf("content of %s was not verified against %s", source.URL, source.ChecksumURL)
+		}
+		sum := checksum(body)
+		if sum != d.checksum {
+			return fmt.Errorf("content of %s has SHA-256 %s, but %s says %s: %w", source.URL, sum, source.ChecksumURL, d.checksum, ErrChecksumMismatch)
+		}
+	}
+	if source.SignatureURL != "" {
+		if d.signatureURL != source.SignatureURL {
+			return fmt.Errorf("content of %s was not verified against %s", source.URL, source.SignatureURL)
+		}
+		err := verifySignature(source.PublicKey, body, d.signature)
+		if err != nil {
+			return fmt.Errorf("content of %s does not match signature %s: %w", source.URL, source.SignatureURL, err)
+		}
+	}
+	return nil
+}
+
+// Download the source's detached checksum and signature, if any, and verify content against them.
+func (downloader *Downloader) getDetached(ctx context.Context, source Source, body []byte) (detached, error) {
+	d := detached{}
+	if source.ChecksumURL != "" {
+		data, err := downloader.getCompanion(ctx, source, source.ChecksumURL)
+		if err != nil {
+			return d, fmt.Errorf("download checksum: %w", err)
+		}
+		d.checksumURL = source.ChecksumURL
+		d.checksum, err = parseChecksum(data)
+		if err != nil {
+			return d, fmt.Errorf("%s: %w", source.ChecksumURL, err)
+		}
+	}
+	if source.SignatureURL != "" {
+		data, err := downloader.getCompanion(ctx, source, source.SignatureURL)
+		if err != nil {
+			return d, fmt.Errorf("download signature: %w", err)
+		}
+		d.signatureURL = source.SignatureURL
+		d.signature = decodeSignature(data)
+	}
+	return d, d.verify(source, body)
+}
+
+// Verify a response against the source's detached checksum and signature, if any.
+// The checksum and signature kept with the response are used if they match the source's configuration,
+// otherwise they are downloaded again. Returns the response with the checksum and signature it was verified with.
+func (downloader *Downloader) verifyDetached(ctx context.Context, source Source, r response) (response, error) {
+	if r.detached.verify(source, r.body) == nil {
+		return r, nil
+	}
+	d, err := downloader.getDetached(ctx, source, r.body)
+	if err != nil {
+		if errors.Is(err, ErrChecksumMismatch) || errors.Is(err, ErrSignatureInvalid) {
+			metrics.IncSourceVerificationFailures(source.URL)
+		}
+		return r, err
+	}
+	r.detached = d
+	return r, nil
+}
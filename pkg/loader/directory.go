@@ -0,0 +1,178 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nais/certificator/pkg/certbundle"
+)
+
+// SymlinkPolicy decides which symbolic links in a directory source are followed.
+type SymlinkPolicy string
+
+const (
+	SymlinksFollow SymlinkPolicy = "follow" // Follow every symbolic link.
+	SymlinksWithin SymlinkPolicy = "within" // Follow symbolic links that resolve to a path inside the source directory.
+	SymlinksSkip   SymlinkPolicy = "skip"   // Ignore symbolic links.
+)
+
+func (policy *SymlinkPolicy) Decode(value string) error {
+	switch SymlinkPolicy(value) {
+	case SymlinksFollow, SymlinksWithin, SymlinksSkip:
+		*policy = SymlinkPolicy(value)
+		return nil
+	default:
+		return fmt.Errorf("unsupported symlink policy %q, expected %q, %q or %q", value, SymlinksFollow, SymlinksWithin, SymlinksSkip)
+	}
+}
+
+func (policy *SymlinkPolicy) UnmarshalText(text []byte) error {
+	return policy.Decode(string(text))
+}
+
+// DirectorySource selects which files in a directory source are read.
+// The zero value reads every file directly in the directory, following symbolic links.
+type DirectorySource struct {
+	// Also read files in subdirectories.
+	Recursive bool
+	// Glob patterns, as understood by filepath.Match, for names of files to read. Every file is read if empty.
+	Include []string
+	// Glob patterns for names of files and subdirectories to skip.
+	Exclude []string
+	// Which symbolic links to follow, SymlinksFollow if empty.
+	Symlinks SymlinkPolicy
+	// Log and skip files that don't contain certificates, instead of failing.
+	Lenient bool
+}
+
+// Check whether a name matches any of the patterns. Patterns are validated when configured.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Decide whether to follow a symbolic link, given the resolved path of the source directory.
+func (d DirectorySource) follow(path, root string) bool {
+	switch d.Symlinks {
+	case SymlinksSkip:
+		return false
+	case SymlinksWithin:
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return false
+		}
+		rel, err := filepath.Rel(root, target)
+		return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	default:
+		return true
+	}
+}
+
+// Read the files in a directory source in lexical order, descending into subdirectories if recursive.
+//
+// Entries with names starting with ".." are always skipped. Kubernetes uses them for the internals of
+// ConfigMap and Secret volumes, whose files are reached through symbolic links in the volume's root directory.
+func readDirectory(source Source, bundle *certbundle.Bundle) error {
+	root, err := filepath.Abs(source.Path)
+	if err != nil {
+		return err
+	}
+	log.Infof("Scanning directory %s%s", root, tags(source))
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	// Directories already scanned, by resolved path, so that symbolic links can't cause loops.
+	visited := map[string]bool{resolvedRoot: true}
+	d := source.Directory
+
+	var walk func(directory string) error
+	walk = func(directory string) error {
+		entries, readErr := os.ReadDir(directory)
+		if readErr != nil {
+			return readErr
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			path := filepath.Join(directory, name)
+			if strings.HasPrefix(name, "..") {
+				continue
+			}
+			if matchAny(d.Exclude, name) {
+				log.Debugf("Skip excluded %s", path)
+				continue
+			}
+
+			var entryErr error
+			mode := entry.Type()
+			if mode&fs.ModeSymlink != 0 {
+				if !d.follow(path, resolvedRoot) {
+					log.Debugf("Skip symbolic link %s", path)
+					continue
+				}
+				stat, statErr := os.Stat(path)
+				if statErr != nil {
+					return statErr
+				}
+				mode = stat.Mode().Type()
+			}
+
+			switch {
+			case mode.IsDir():
+				if !d.Recursive {
+					continue
+				}
+				resolved, resolveErr := filepath.EvalSymlinks(path)
+				if resolveErr != nil {
+					return resolveErr
+				}
+				if visited[resolved] {
+					log.Debugf("Skip %s, already scanned", path)
+					continue
+				}
+				visited[resolved] = true
+				entryErr = walk(path)
+			case !mode.IsRegular():
+				continue
+			case len(d.Include) > 0 && !matchAny(d.Include, name):
+				log.Debugf("Skip %s, not included", path)
+				continue
+			default:
+				entryErr = readFile(path, source, bundle)
+			}
+			if entryErr != nil {
+				return entryErr
+			}
+		}
+		return nil
+	}
+	return walk(root)
+}
+
+// Read the certificates in a file of a directory source.
+func readFile(path string, source Source, bundle *certbundle.Bundle) error {
+	log.Infof("Load %s", path)
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return err
+	}
+	err = bundle.ReadAllFrom(path, f, source.bundleOptions()...)
+	if closeErr := f.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err != nil && source.Directory.Lenient && errors.Is(err, certbundle.ErrUnparseable) {
+		log.Warnf("Skipping file without certificates: %s", err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package loader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A successful response as persisted in the cache directory.
+// SHA256 is the checksum of Body, and guards against truncated or corrupted files.
+// The detached checksum and signature the body was verified with are kept, so that it can be verified again when read back.
+type cachedResponse struct {
+	URL              string    `json:"url"`
+	ETag             string    `json:"etag,omitempty"`
+	LastModified     string    `json:"lastModified,omitempty"`
+	FetchedAt        time.Time `json:"fetchedAt"`
+	SHA256           string    `json:"sha256"`
+	Body             []byte    `json:"body"`
+	ChecksumURL      string    `json:"checksumURL,omitempty"`
+	DetachedChecksum string    `json:"detachedChecksum,omitempty"`
+	SignatureURL     string    `json:"signatureURL,omitempty"`
+	Signature        []byte    `json:"signature,omitempty"`
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Path of the cache file for a URL.
+func cachePath(directory, url string) string {
+	return filepath.Join(directory, checksum([]byte(url))+".json")
+}
+
+// Read the persisted response for a URL. Returns os.ErrNotExist if nothing has been persisted yet.
+func readCache(directory, url string) (response, error) {
+	data, err := os.ReadFile(cachePath(directory, url))
+	if err != nil {
+		return response{}, err
+	}
+	cached := cachedResponse{}
+	err = json.Unmarshal(data, &cached)
+	if err != nil {
+		return response{}, err
+	}
+	if cached.URL != url {
+		return response{}, fmt.Errorf("cache file holds content of %s", cached.URL)
+	}
+	if checksum(cached.Body) != cached.SHA256 {
+		return response{}, fmt.Errorf("checksum mismatch")
+	}
+	return response{
+		etag:         cached.ETag,
+		lastModified: cached.LastModified,
+		body:         cached.Body,
+		fetchedAt:    cached.FetchedAt,
+		detached: detached{
+			checksumURL:  cached.ChecksumURL,
+			checksum:     cached.DetachedChecksum,
+			signatureURL: cached.SignatureURL,
+			signature:    cached.Signature,
+		},
+	}, nil
+}
+
+// Persist the response for a URL. The file is replaced atomically, so that a crash never leaves a partial file behind.
+func writeCache(directory, url string, r response) error {
+	data, err := json.Marshal(cachedResponse{
+		URL:              url,
+		ETag:             r.etag,
+		LastModified:     r.lastModified,
+		FetchedAt:        r.fetchedAt,
+		SHA256:           checksum(r.body),
+		Body:             r.body,
+		ChecksumURL:      r.detached.checksumURL,
+		DetachedChecksum: r.detached.checksum,
+		SignatureURL:     r.detached.signatureURL,
+		Signature:        r.detached.signature,
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(directory, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), cachePath(directory, url))
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
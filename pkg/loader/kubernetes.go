@@ -0,0 +1,242 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/nais/certificator/pkg/certbundle"
+	"github.com/nais/certificator/pkg/resource"
+)
+
+// KubernetesSource selects ConfigMaps or Secrets in a namespace to read certificates from,
+// either by name or by label selector. Only the given keys are read, or all keys except
+// well-known private key entries, such as tls.key, if none are given.
+type KubernetesSource struct {
+	Kind          resource.Kind
+	Namespace     string
+	Name          string
+	LabelSelector string
+	Keys          []string
+	// Log and skip keys that don't contain certificates, instead of failing.
+	Lenient bool
+}
+
+func (k KubernetesSource) String() string {
+	if k.Name != "" {
+		return fmt.Sprintf("%s/%s/%s", k.Kind, k.Namespace, k.Name)
+	}
+	return fmt.Sprintf("%s/%s?labelSelector=%s", k.Kind, k.Namespace, k.LabelSelector)
+}
+
+// List options selecting the source's resources.
+func (k KubernetesSource) listOptions(options *metav1.ListOptions) {
+	if k.Name != "" {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", k.Name).String()
+	} else {
+		options.LabelSelector = k.LabelSelector
+	}
+}
+
+// A ConfigMap or Secret's name and data.
+type resourceData struct {
+	name string
+	data map[string][]byte
+}
+
+// Get the source's resources, sorted by name.
+// A named resource is fetched directly, so that its absence is an error.
+func (k KubernetesSource) list(ctx context.Context, client kubernetes.Interface) ([]resourceData, error) {
+	options := metav1.ListOptions{}
+	k.listOptions(&options)
+
+	var configMaps []corev1.ConfigMap
+	var secrets []corev1.Secret
+	switch k.Kind {
+	case resource.KindConfigMap:
+		if k.Name != "" {
+			cm, err := client.CoreV1().ConfigMaps(k.Namespace).Get(ctx, k.Name, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			configMaps = []corev1.ConfigMap{*cm}
+		} else {
+			list, err := client.CoreV1().ConfigMaps(k.Namespace).List(ctx, options)
+			if err != nil {
+				return nil, err
+			}
+			configMaps = list.Items
+		}
+	case resource.KindSecret:
+		if k.Name != "" {
+			secret, err := client.CoreV1().Secrets(k.Namespace).Get(ctx, k.Name, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			secrets = []corev1.Secret{*secret}
+		} else {
+			list, err := client.CoreV1().Secrets(k.Namespace).List(ctx, options)
+			if err != nil {
+				return nil, err
+			}
+			secrets = list.Items
+		}
+	default:
+		return nil, fmt.Errorf("unsupported resource kind %q", k.Kind)
+	}
+
+	result := make([]resourceData, 0, len(configMaps)+len(secrets))
+	for _, cm := range configMaps {
+		data := make(map[string][]byte, len(cm.Data)+len(cm.BinaryData))
+		for key, value := range cm.Data {
+			data[key] = []byte(value)
+		}
+		maps.Copy(data, cm.BinaryData)
+		result = append(result, resourceData{cm.Name, data})
+	}
+	for _, secret := range secrets {
+		result = append(result, resourceData{secret.Name, secret.Data})
+	}
+	slices.SortFunc(result, func(a, b resourceData) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return result, nil
+}
+
+// Keys that hold private keys in well-known Secret types, which are skipped unless asked for explicitly.
+var privateKeyKeys = map[string]bool{
+	corev1.TLSPrivateKeyKey:  true,
+	corev1.SSHAuthPrivateKey: true,
+}
+
+// Read certificates from the ConfigMaps or Secrets selected by a source.
+// Resources are read in order of name, and keys within a resource in lexical order.
+func readKubernetes(ctx context.Context, client kubernetes.Interface, source Source, bundle *certbundle.Bundle) error {
+	if client == nil {
+		return fmt.Errorf("%s: no Kubernetes client configured", source)
+	}
+	if source.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, source.Timeout)
+		defer cancel()
+	}
+	k := source.Kubernetes
+	log.Infof("Reading certificates from %s%s", k, tags(source))
+
+	resources, err := k.list(ctx, client)
+	if err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+	for _, item := range resources {
+		keys := k.Keys
+		if len(keys) == 0 {
+			keys = make([]string, 0, len(item.data))
+			for _, key := range slices.Sorted(maps.Keys(item.data)) {
+				if !privateKeyKeys[key] {
+					keys = append(keys, key)
+				}
+			}
+		}
+		for _, key := range keys {
+			data, ok := item.data[key]
+			if !ok {
+				return fmt.Errorf("%s/%s/%s: key %q not found", k.Kind, k.Namespace, item.name, key)
+			}
+			name := fmt.Sprintf("%s/%s/%s/%s", k.Kind, k.Namespace, item.name, key)
+			log.Infof("Load %s", name)
+			err = bundle.ReadAllFrom(name, bytes.NewReader(data), source.bundleOptions()...)
+			if err != nil && k.Lenient && errors.Is(err, certbundle.ErrUnparseable) {
+				log.Warnf("Skipping key without certificates: %s", err)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WatchKubernetes watches the resources selected by Kubernetes sources, and sends to a channel
+// once the watch is in place, and whenever any of them are added, changed or deleted after that.
+// Blocks until the context is canceled, then closes the channel.
+func WatchKubernetes(ctx context.Context, client kubernetes.Interface, sources []Source, changes chan<- struct{}) error {
+	defer close(changes)
+
+	send := func() {
+		select {
+		case <-ctx.Done():
+		case changes <- struct{}{}:
+		}
+	}
+	handler := cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(_ any, isInInitialList bool) {
+			if !isInInitialList {
+				send()
+			}
+		},
+		UpdateFunc: func(oldObj, newObj any) {
+			oldMeta, oldOK := oldObj.(metav1.Object)
+			newMeta, newOK := newObj.(metav1.Object)
+			if oldOK && newOK && oldMeta.GetResourceVersion() == newMeta.GetResourceVersion() {
+				return
+			}
+			send()
+		},
+		DeleteFunc: func(any) {
+			send()
+		},
+	}
+
+	factories := make([]informers.SharedInformerFactory, 0, len(sources))
+	for _, source := range sources {
+		if source.Type != SourceKubernetes {
+			continue
+		}
+		k := source.Kubernetes
+		factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+			informers.WithNamespace(k.Namespace),
+			informers.WithTweakListOptions(k.listOptions),
+		)
+		var informer cache.SharedIndexInformer
+		switch k.Kind {
+		case resource.KindConfigMap:
+			informer = factory.Core().V1().ConfigMaps().Informer()
+		case resource.KindSecret:
+			informer = factory.Core().V1().Secrets().Informer()
+		default:
+			return fmt.Errorf("%s: unsupported resource kind %q", source, k.Kind)
+		}
+		_, err := informer.AddEventHandler(handler)
+		if err != nil {
+			return err
+		}
+		factory.Start(ctx.Done())
+		factories = append(factories, factory)
+		log.Debugf("Watching %s for changes", k)
+	}
+
+	// Changes made after the resources were last read, but before the watch was in place, are only
+	// visible in the initial list. Report a change once it is in, so that they are picked up.
+	for _, factory := range factories {
+		factory.WaitForCacheSync(ctx.Done())
+	}
+	if len(factories) > 0 {
+		send()
+	}
+
+	<-ctx.Done()
+	return nil
+}
@@ -3,116 +3,334 @@ package loader
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/nais/certificator/pkg/certbundle"
+	"github.com/nais/certificator/pkg/metrics"
 	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
 )
 
-// Download some content and copy it into an io.Reader buffer.
-func download(ctx context.Context, url string) (io.Reader, error) {
+// Downloader downloads certificates from URLs. It remembers the validators and content of previous responses,
+// and makes conditional requests so that unmodified content isn't transferred again.
+// Content that can no longer be downloaded may be reused for a while, see WithMaxStaleness.
+type Downloader struct {
+	client         *http.Client
+	kubernetes     kubernetes.Interface
+	maxStaleness   time.Duration
+	cacheDirectory string
+	lock           sync.Mutex
+	responses      map[string]response
+}
+
+// A previous successful response, and when its content was last confirmed to be current.
+type response struct {
+	etag         string
+	lastModified string
+	body         []byte
+	fetchedAt    time.Time
+	detached     detached
+}
+
+// Option configures a Downloader.
+type Option func(*Downloader)
+
+// WithHTTPClient sets the HTTP client used for downloads. Defaults to http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(downloader *Downloader) {
+		downloader.client = client
+	}
+}
+
+// WithMaxStaleness allows reusing the last successfully downloaded content of a URL when it fails to download,
+// as long as that content is no older than the given duration. Zero, the default, never reuses content.
+func WithMaxStaleness(maxStaleness time.Duration) Option {
+	return func(downloader *Downloader) {
+		downloader.maxStaleness = maxStaleness
+	}
+}
+
+// WithCacheDirectory persists the last successfully downloaded content of every URL in a directory,
+// so that it survives restarts. Persisted content is subject to the same max staleness as content in memory.
+func WithCacheDirectory(directory string) Option {
+	return func(downloader *Downloader) {
+		downloader.cacheDirectory = directory
+	}
+}
+
+// WithKubernetesClient sets the client used to read Kubernetes sources.
+func WithKubernetesClient(client kubernetes.Interface) Option {
+	return func(downloader *Downloader) {
+		downloader.kubernetes = client
+	}
+}
+
+func NewDownloader(opts ...Option) *Downloader {
+	downloader := &Downloader{
+		client:    http.DefaultClient,
+		responses: make(map[string]response),
+	}
+	for _, opt := range opts {
+		opt(downloader)
+	}
+	return downloader
+}
+
+// Download some content, or reuse the content of the previous response if the server reports it as not modified.
+// Content that fails verification is never stored.
+func (downloader *Downloader) download(ctx context.Context, source Source) ([]byte, error) {
+	url := source.URL
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
 	if err != nil {
 		return nil, err
 	}
+	err = source.Auth.apply(req)
+	if err != nil {
+		return nil, err
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	previous, cached := downloader.previous(url)
+	if cached {
+		if previous.etag != "" {
+			req.Header.Set("If-None-Match", previous.etag)
+		}
+		if previous.lastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.lastModified)
+		}
+	}
+
+	resp, err := downloader.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode != 200 {
+	if resp.StatusCode == http.StatusNotModified && cached {
+		log.Debugf("%s not modified; reusing previous content", url)
+		metrics.IncDownloadsNotModified()
+		err = downloader.verify(source, previous.body)
+		if err != nil {
+			return nil, err
+		}
+		previous, err = downloader.verifyDetached(ctx, source, previous)
+		if err != nil {
+			return nil, err
+		}
+		previous.fetchedAt = time.Now()
+		downloader.store(url, previous)
+		return previous.body, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
 	}
 
-	buf := &bytes.Buffer{}
-	_, err = io.Copy(buf, resp.Body)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	return buf, nil
+	err = downloader.verify(source, body)
+	if err != nil {
+		return nil, err
+	}
+	r, err := downloader.verifyDetached(ctx, source, response{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		body:         body,
+		fetchedAt:    time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	downloader.store(url, r)
+
+	return body, nil
 }
 
-// BundleFromURLs creates a certificate bundle from the content of a list of URLs.
-func BundleFromURLs(ctx context.Context, bundle *certbundle.Bundle, urls []string) error {
-	errors := make(chan error, len(urls)+1)
-	readers := make(chan io.Reader, len(urls)+1)
+// Return the previous successful response for a URL, from memory or the cache directory.
+func (downloader *Downloader) previous(url string) (response, bool) {
+	downloader.lock.Lock()
+	defer downloader.lock.Unlock()
 
-	wg := &sync.WaitGroup{}
-	wg.Add(len(urls))
-	for _, url := range urls {
-		go func(u string) {
-			defer wg.Done()
-			log.Infof("Downloading certificates from %s", u)
-			r, err := download(ctx, u)
-			if err != nil {
-				errors <- fmt.Errorf("failed to download %s: %w", u, err)
-			} else {
-				readers <- r
-			}
-		}(url)
+	r, ok := downloader.responses[url]
+	if ok || downloader.cacheDirectory == "" {
+		return r, ok
 	}
-	wg.Wait()
 
-	close(errors)
-	close(readers)
-
-	for err := range errors {
-		if err != nil {
-			return err
+	r, err := readCache(downloader.cacheDirectory, url)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warnf("Ignoring cached content of %s: %s", url, err)
 		}
+		return response{}, false
 	}
+	log.Infof("Loaded cached content of %s downloaded at %s", url, r.fetchedAt.Format(time.RFC3339))
+	downloader.responses[url] = r
+	metrics.SetSourceFetched(url, r.fetchedAt)
+	return r, true
+}
 
-	for r := range readers {
-		err := bundle.ReadAll(r)
-		if err != nil {
-			return err
-		}
+func (downloader *Downloader) store(url string, r response) {
+	downloader.lock.Lock()
+	defer downloader.lock.Unlock()
+
+	downloader.responses[url] = r
+	metrics.SetSourceFetched(url, r.fetchedAt)
+
+	if downloader.cacheDirectory == "" {
+		return
 	}
+	err := writeCache(downloader.cacheDirectory, url, r)
+	if err != nil {
+		log.Warnf("Failed to cache content of %s: %s", url, err)
+	}
+}
 
-	return nil
+// Verify content against the source's expectations, and count failures.
+func (downloader *Downloader) verify(source Source, body []byte) error {
+	err := source.verify(body)
+	if err != nil {
+		metrics.IncSourceVerificationFailures(source.URL)
+	}
+	return err
 }
 
-// BundleFromPaths creates a certificate bundle from the content of file system directories.
-func BundleFromPaths(paths []string, bundle *certbundle.Bundle) error {
-	var err error
+// Download some content, falling back to the last successfully downloaded content if it is recent enough.
+func (downloader *Downloader) fetch(ctx context.Context, source Source) ([]byte, error) {
+	url := source.URL
+	if source.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, source.Timeout)
+		defer cancel()
+	}
+	body, err := downloader.download(ctx, source)
+	if err == nil {
+		return body, nil
+	}
+	metrics.IncSourceFailures(url)
 
-	for _, directory := range paths {
-		directory, err = filepath.Abs(directory)
+	previous, cached := downloader.previous(url)
+	if !cached || downloader.maxStaleness <= 0 {
+		return nil, err
+	}
+	age := time.Since(previous.fetchedAt)
+	if age > downloader.maxStaleness {
+		return nil, fmt.Errorf("%w; last successful download %s ago is older than %s", err, age.Round(time.Second), downloader.maxStaleness)
+	}
+
+	if source.verify(previous.body) != nil {
+		return nil, err
+	}
+	_, verifyErr := downloader.verifyDetached(ctx, source, previous)
+	if verifyErr != nil {
+		log.Warnf("Not using previous content of %s: %s", url, verifyErr)
+		return nil, err
+	}
+
+	log.Warnf("Failed to download %s: %s; using content downloaded %s ago", url, err, age.Round(time.Second))
+	return previous.body, nil
+}
+
+// BundleFromSources adds the content of a list of sources to a certificate bundle.
+// URLs are downloaded concurrently before anything is added. Content is then added to the bundle
+// in the order the sources are given, regardless of which download finishes first.
+func (downloader *Downloader) BundleFromSources(ctx context.Context, bundle *certbundle.Bundle, sources []Source) error {
+	bodies, err := downloader.downloadAll(ctx, sources)
+	if err != nil {
+		return err
+	}
+	for i, source := range sources {
+		switch source.Type {
+		case SourceDirectory:
+			err = readDirectory(source, bundle)
+		case SourceKubernetes:
+			err = readKubernetes(ctx, downloader.kubernetes, source, bundle)
+		case SourceURL:
+			err = bundle.ReadAllFrom(source.URL, bytes.NewReader(bodies[i]), source.bundleOptions()...)
+		default:
+			err = fmt.Errorf("%s: unsupported source type %q", source, source.Type)
+		}
 		if err != nil {
 			return err
 		}
-		log.Infof("Scanning directory %s", directory)
-		entries, err := os.ReadDir(directory)
+	}
+	return nil
+}
+
+// BundleFromURLs adds the content of a list of URLs to a certificate bundle. See Source for the URL format.
+// Content is added to the bundle in the order the URLs are given, regardless of which download finishes first.
+func (downloader *Downloader) BundleFromURLs(ctx context.Context, bundle *certbundle.Bundle, urls []string) error {
+	sources := make([]Source, len(urls))
+	for i, raw := range urls {
+		source, err := ParseSource(raw)
 		if err != nil {
 			return err
 		}
-		for _, entry := range entries {
-			if entry.IsDir() {
-				continue
-			}
-			path := filepath.Join(directory, entry.Name())
-			log.Infof("Load %s", path)
-			f, err := os.Open(filepath.Clean(path))
-			if err != nil {
-				return err
-			}
-			err = bundle.ReadAll(f)
-			if closeErr := f.Close(); closeErr != nil && err == nil {
-				err = closeErr
-			}
+		sources[i] = source
+	}
+	return downloader.BundleFromSources(ctx, bundle, sources)
+}
+
+// Download the content of every URL source concurrently. Returns the content by source index, nil for other sources.
+func (downloader *Downloader) downloadAll(ctx context.Context, sources []Source) ([][]byte, error) {
+	errors := make([]error, len(sources))
+	bodies := make([][]byte, len(sources))
+
+	wg := &sync.WaitGroup{}
+	for i, source := range sources {
+		if source.Type != SourceURL {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, s Source) {
+			defer wg.Done()
+			log.Infof("Downloading certificates from %s%s", s.URL, tags(s))
+			body, err := downloader.fetch(ctx, s)
 			if err != nil {
-				return err
+				errors[i] = fmt.Errorf("failed to download %s: %w", s.URL, err)
+			} else {
+				bodies[i] = body
 			}
+		}(i, source)
+	}
+	wg.Wait()
+
+	for _, err := range errors {
+		if err != nil {
+			return nil, err
 		}
 	}
+	return bodies, nil
+}
 
+// BundleFromURLs creates a certificate bundle from the content of a list of URLs, without any caching.
+func BundleFromURLs(ctx context.Context, bundle *certbundle.Bundle, urls []string) error {
+	return NewDownloader().BundleFromURLs(ctx, bundle, urls)
+}
+
+// BundleFromPaths creates a certificate bundle from the content of file system directories.
+// Directories are read in the order given, and files within a directory in lexical order.
+func BundleFromPaths(paths []string, bundle *certbundle.Bundle) error {
+	for _, path := range paths {
+		err := readDirectory(Source{Type: SourceDirectory, Path: path}, bundle)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// Format a source's tags for logging.
+func tags(source Source) string {
+	if len(source.Tags) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" %v", source.Tags)
+}
@@ -0,0 +1,212 @@
+package loader
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nais/certificator/pkg/certbundle"
+)
+
+// SourceType is the kind of location a Source reads certificates from.
+type SourceType string
+
+const (
+	SourceURL        SourceType = "url"
+	SourceDirectory  SourceType = "directory"
+	SourceKubernetes SourceType = "kubernetes"
+)
+
+// Source is a location to read certificates from, together with how to read them and the content it is expected to have.
+//
+// URL sources can be given as a string, with expectations in the URL fragment, which is never sent to the server:
+//
+//	https://example.com/ca.pem#sha256=<hex>
+//	https://example.com/ca.pem#fingerprint=<hex>&fingerprint=<hex>
+//	https://example.com/ca.pem#checksum=ca.pem.sha256
+//	https://example.com/ca.pem#signature=ca.pem.sig&pubkey=/path/to/key.pem
+//
+// With sha256, the body must have the given SHA-256 checksum. With fingerprint, every certificate
+// must have one of the given SHA-256 fingerprints. With checksum, the body must have the SHA-256 checksum
+// found in the file at the given URL. With signature, the body must match the signature found at the given URL,
+// made with the private key belonging to the public key in the pubkey file.
+// Checksum and signature URLs are relative to the source URL.
+type Source struct {
+	Type       SourceType
+	URL        string
+	Path       string
+	Directory  DirectorySource
+	Kubernetes KubernetesSource
+
+	// Timeout for downloading URL sources and reading Kubernetes sources, or zero for no timeout beyond the context's.
+	Timeout time.Duration
+	Auth    Auth
+
+	SHA256       string
+	Fingerprints []string
+	ChecksumURL  string
+	SignatureURL string
+	PublicKey    crypto.PublicKey
+
+	// Policies overriding the bundle's policies for this source, if set.
+	ValidityPolicy *certbundle.Policy
+	CAPolicy       *certbundle.Policy
+
+	// Free-form tags, shown in logs and the status API.
+	Tags []string
+}
+
+// Auth holds credentials sent with every request to a URL source, including requests for checksums and signatures.
+// Credentials are read from files on every request, so that they can be rotated.
+type Auth struct {
+	BearerTokenFile string
+	Username        string
+	PasswordFile    string
+	Headers         map[string]string
+}
+
+// Add credentials to a request.
+func (auth Auth) apply(req *http.Request) error {
+	for key, value := range auth.Headers {
+		req.Header.Set(key, value)
+	}
+	if auth.BearerTokenFile != "" {
+		token, err := os.ReadFile(auth.BearerTokenFile)
+		if err != nil {
+			return fmt.Errorf("read bearer token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	if auth.Username != "" {
+		var password []byte
+		if auth.PasswordFile != "" {
+			var err error
+			password, err = os.ReadFile(auth.PasswordFile)
+			if err != nil {
+				return fmt.Errorf("read password: %w", err)
+			}
+		}
+		req.SetBasicAuth(auth.Username, strings.TrimRight(string(password), "\r\n"))
+	}
+	return nil
+}
+
+// String identifies the source in logs, metrics and the bundle.
+func (source Source) String() string {
+	switch source.Type {
+	case SourceDirectory:
+		return source.Path
+	case SourceKubernetes:
+		return source.Kubernetes.String()
+	default:
+		return source.URL
+	}
+}
+
+// ErrChecksumMismatch is returned when downloaded content doesn't have the expected checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ParseSource parses a URL source with optional expectations in the fragment.
+func ParseSource(raw string) (Source, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Source{}, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return Source{}, fmt.Errorf("%s: unsupported scheme %q", raw, u.Scheme)
+	}
+	params, err := url.ParseQuery(u.Fragment)
+	if err != nil {
+		return Source{}, fmt.Errorf("%s: parse fragment: %w", raw, err)
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	source := Source{
+		Type: SourceURL,
+		URL:  u.String(),
+	}
+	single := func(key string, values []string) (string, error) {
+		if len(values) != 1 {
+			return "", fmt.Errorf("%s: %s must be given once", raw, key)
+		}
+		return values[0], nil
+	}
+	companion := func(key string, values []string) (string, error) {
+		value, err := single(key, values)
+		if err != nil {
+			return "", err
+		}
+		ref, err := url.Parse(value)
+		if err != nil {
+			return "", fmt.Errorf("%s: %s: %w", raw, key, err)
+		}
+		return u.ResolveReference(ref).String(), nil
+	}
+	var pubkey string
+	for key, values := range params {
+		switch key {
+		case "sha256":
+			source.SHA256, err = single(key, values)
+			source.SHA256 = strings.ToLower(source.SHA256)
+		case "fingerprint":
+			source.Fingerprints = values
+		case "checksum":
+			source.ChecksumURL, err = companion(key, values)
+		case "signature":
+			source.SignatureURL, err = companion(key, values)
+		case "pubkey":
+			pubkey, err = single(key, values)
+		default:
+			return Source{}, fmt.Errorf("%s: unsupported parameter %q in fragment", raw, key)
+		}
+		if err != nil {
+			return Source{}, err
+		}
+	}
+	if (source.SignatureURL == "") != (pubkey == "") {
+		return Source{}, fmt.Errorf("%s: signature and pubkey must be given together", raw)
+	}
+	if pubkey != "" {
+		source.PublicKey, err = ReadPublicKey(pubkey)
+		if err != nil {
+			return Source{}, fmt.Errorf("%s: read public key: %w", raw, err)
+		}
+	}
+	return source, nil
+}
+
+// Options for reading the source's certificates into a bundle.
+func (source Source) bundleOptions() []certbundle.Option {
+	opts := make([]certbundle.Option, 0)
+	if len(source.Fingerprints) > 0 {
+		opts = append(opts, certbundle.WithFingerprints(source.Fingerprints...))
+	}
+	if source.ValidityPolicy != nil {
+		opts = append(opts, certbundle.WithValidityPolicy(*source.ValidityPolicy))
+	}
+	if source.CAPolicy != nil {
+		opts = append(opts, certbundle.WithCAPolicy(*source.CAPolicy))
+	}
+	return opts
+}
+
+// Verify that downloaded content matches the source's checksum and fingerprints, if any.
+func (source Source) verify(body []byte) error {
+	if source.SHA256 != "" {
+		sum := checksum(body)
+		if sum != source.SHA256 {
+			return fmt.Errorf("content of %s has SHA-256 %s, expected %s: %w", source.URL, sum, source.SHA256, ErrChecksumMismatch)
+		}
+	}
+	if len(source.Fingerprints) > 0 {
+		return certbundle.New("").ReadAllFrom(source.URL, bytes.NewReader(body), certbundle.WithFingerprints(source.Fingerprints...))
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"strconv"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -11,7 +12,20 @@ const (
 	subsystem = "certificator"
 )
 
-const labelErrorCode = "error_code"
+const (
+	labelErrorCode   = "error_code"
+	labelSubject     = "subject"
+	labelFingerprint = "fingerprint"
+	labelSource      = "source"
+)
+
+// Certificate describes a certificate in the bundle.
+type Certificate struct {
+	Subject     string
+	Fingerprint string
+	Sources     []string
+	NotAfter    time.Time
+}
 
 var (
 	namespaces = prometheus.NewGauge(prometheus.GaugeOpts{
@@ -35,6 +49,20 @@ var (
 		Help:      "Number of CA certificates in the bundle.",
 	})
 
+	certificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "certificate_expiry_timestamp_seconds",
+		Help:      "Expiry time of each CA certificate in the bundle, as a Unix timestamp.",
+	}, []string{labelSubject, labelFingerprint, labelSource})
+
+	soonestExpiry = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "certificate_soonest_expiry_timestamp_seconds",
+		Help:      "Expiry time of the CA certificate in the bundle that expires first, as a Unix timestamp.",
+	})
+
 	sync = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: namespace,
 		Subsystem: subsystem,
@@ -42,12 +70,61 @@ var (
 		Help:      "Indicates how many Kubernetes ConfigMap writes are attempted.",
 	}, []string{labelErrorCode})
 
+	syncSkipped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "synchronizations_skipped",
+		Help:      "Indicates how many Kubernetes resource writes were skipped because the resource was already up to date.",
+	})
+
+	driftRepairs = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "drift_repairs",
+		Help:      "Indicates how many times a namespace was re-queued because its CA bundle resources were deleted or changed.",
+	})
+
+	downloadsNotModified = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "downloads_not_modified",
+		Help:      "Indicates how many downloads reused cached content because the source was not modified.",
+	})
+
+	sourceFetched = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "source_fetched_timestamp_seconds",
+		Help:      "When the content of each URL source was last downloaded or confirmed unmodified, as a Unix timestamp.",
+	}, []string{labelSource})
+
+	sourceFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "source_failures",
+		Help:      "Indicates how many downloads failed for each URL source.",
+	}, []string{labelSource})
+
+	sourceVerificationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "source_verification_failures",
+		Help:      "Indicates how many times the content of each URL source did not match its pinned checksum or fingerprints.",
+	}, []string{labelSource})
+
 	refresh = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: namespace,
 		Subsystem: subsystem,
 		Name:      "downloads",
 		Help:      "Indicates how many certificate refreshes attempted.",
 	}, []string{labelErrorCode})
+
+	configReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "config_reloads",
+		Help:      "Indicates how many times the configuration file was reloaded after changing.",
+	}, []string{labelErrorCode})
 )
 
 func init() {
@@ -55,13 +132,23 @@ func init() {
 		namespaces,
 		pendingNamespaces,
 		certificates,
+		certificateExpiry,
+		soonestExpiry,
 		sync,
+		syncSkipped,
+		driftRepairs,
+		downloadsNotModified,
+		sourceFetched,
+		sourceFailures,
+		sourceVerificationFailures,
 		refresh,
+		configReloads,
 	)
 
 	namespaces.Set(0)
 	pendingNamespaces.Set(0)
 	certificates.Set(0)
+	soonestExpiry.Set(0)
 	sync.WithLabelValues("0")
 	sync.WithLabelValues("1")
 	refresh.WithLabelValues("0")
@@ -76,14 +163,80 @@ func SetPendingNamespaces(count int) {
 	pendingNamespaces.Set(float64(count))
 }
 
-func SetCertificates(count int) {
-	certificates.Set(float64(count))
+// Label values of the certificate expiry series currently exported.
+// Only written by SetCertificates, which is not safe for concurrent use.
+var certificateSeries = make(map[[3]string]bool)
+
+// SetCertificates replaces the certificate metrics with the contents of the current bundle.
+// Certificates that are no longer in the bundle are removed. Series are updated in place,
+// so that a scrape never sees the metric empty.
+func SetCertificates(certs []Certificate) {
+	certificates.Set(float64(len(certs)))
+
+	series := make(map[[3]string]bool)
+	var soonest time.Time
+	for _, cert := range certs {
+		expiry := float64(cert.NotAfter.Unix())
+		sources := cert.Sources
+		if len(sources) == 0 {
+			sources = []string{""}
+		}
+		for _, source := range sources {
+			labels := [3]string{cert.Subject, cert.Fingerprint, source}
+			certificateExpiry.WithLabelValues(labels[:]...).Set(expiry)
+			series[labels] = true
+		}
+		if soonest.IsZero() || cert.NotAfter.Before(soonest) {
+			soonest = cert.NotAfter
+		}
+	}
+
+	for labels := range certificateSeries {
+		if !series[labels] {
+			certificateExpiry.DeleteLabelValues(labels[:]...)
+		}
+	}
+	certificateSeries = series
+
+	if soonest.IsZero() {
+		soonestExpiry.Set(0)
+	} else {
+		soonestExpiry.Set(float64(soonest.Unix()))
+	}
 }
 
 func IncSync(errorCode int) {
 	sync.WithLabelValues(strconv.Itoa(errorCode)).Inc()
 }
 
+func IncSyncSkipped() {
+	syncSkipped.Inc()
+}
+
+func IncDriftRepairs() {
+	driftRepairs.Inc()
+}
+
+func IncDownloadsNotModified() {
+	downloadsNotModified.Inc()
+}
+
+func SetSourceFetched(source string, t time.Time) {
+	sourceFetched.WithLabelValues(source).Set(float64(t.Unix()))
+}
+
+func IncSourceFailures(source string) {
+	sourceFailures.WithLabelValues(source).Inc()
+}
+
+func IncSourceVerificationFailures(source string) {
+	sourceVerificationFailures.WithLabelValues(source).Inc()
+}
+
 func IncRefresh(errorCode int) {
 	refresh.WithLabelValues(strconv.Itoa(errorCode)).Inc()
 }
+
+func IncConfigReload(errorCode int) {
+	configReloads.WithLabelValues(strconv.Itoa(errorCode)).Inc()
+}
@@ -0,0 +1,83 @@
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nais/certificator/pkg/metrics"
+)
+
+const (
+	expiryMetric  = "nais_certificator_certificate_expiry_timestamp_seconds"
+	soonestMetric = "nais_certificator_certificate_soonest_expiry_timestamp_seconds"
+)
+
+func count(t *testing.T, name string) int {
+	n, err := testutil.GatherAndCount(prometheus.DefaultGatherer, name)
+	assert.NoError(t, err)
+	return n
+}
+
+func TestSetCertificates(t *testing.T) {
+	soon := time.Unix(1900000000, 0)
+	late := time.Unix(2000000000, 0)
+
+	metrics.SetCertificates([]metrics.Certificate{
+		{Subject: "CN=first", Fingerprint: "aa", Sources: []string{"/a", "/b"}, NotAfter: late},
+		{Subject: "CN=second", Fingerprint: "bb", Sources: []string{"/a"}, NotAfter: soon},
+	})
+
+	assert.Equal(t, 3, count(t, expiryMetric))
+	err := testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(`
+# HELP nais_certificator_certificate_soonest_expiry_timestamp_seconds Expiry time of the CA certificate in the bundle that expires first, as a Unix timestamp.
+# TYPE nais_certificator_certificate_soonest_expiry_timestamp_seconds gauge
+nais_certificator_certificate_soonest_expiry_timestamp_seconds 1.9e+09
+`), soonestMetric)
+	assert.NoError(t, err)
+
+	metrics.SetCertificates([]metrics.Certificate{
+		{Subject: "CN=first", Fingerprint: "aa", Sources: []string{"/a"}, NotAfter: late},
+	})
+
+	assert.Equal(t, 1, count(t, expiryMetric))
+	err = testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(`
+# HELP nais_certificator_certificate_expiry_timestamp_seconds Expiry time of each CA certificate in the bundle, as a Unix timestamp.
+# TYPE nais_certificator_certificate_expiry_timestamp_seconds gauge
+nais_certificator_certificate_expiry_timestamp_seconds{fingerprint="aa",source="/a",subject="CN=first"} 2e+09
+`), expiryMetric)
+	assert.NoError(t, err)
+
+	metrics.SetCertificates(nil)
+
+	assert.Equal(t, 0, count(t, expiryMetric))
+}
+
+func TestSetCertificatesKeepsCurrentSeries(t *testing.T) {
+	certs := []metrics.Certificate{
+		{Subject: "CN=first", Fingerprint: "aa", Sources: []string{"/a", "/b"}, NotAfter: time.Unix(2000000000, 0)},
+	}
+	metrics.SetCertificates(certs)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100000 {
+			metrics.SetCertificates(certs)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			assert.Equal(t, 2, count(t, expiryMetric))
+			metrics.SetCertificates(nil)
+			return
+		default:
+			assert.Equal(t, 2, count(t, expiryMetric), "series of unchanged certificates must not disappear")
+		}
+	}
+}
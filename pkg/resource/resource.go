@@ -0,0 +1,29 @@
+// Package resource names the kinds of Kubernetes resources certificator reads and writes,
+// without depending on the Kubernetes client.
+package resource
+
+import (
+	"fmt"
+)
+
+// Kind selects a type of Kubernetes resource holding certificates.
+type Kind string
+
+const (
+	KindConfigMap Kind = "configmap"
+	KindSecret    Kind = "secret"
+)
+
+func (kind *Kind) Decode(value string) error {
+	switch Kind(value) {
+	case KindConfigMap, KindSecret:
+		*kind = Kind(value)
+		return nil
+	default:
+		return fmt.Errorf("unsupported resource kind %q, expected %q or %q", value, KindConfigMap, KindSecret)
+	}
+}
+
+func (kind *Kind) UnmarshalText(text []byte) error {
+	return kind.Decode(string(text))
+}
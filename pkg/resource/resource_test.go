@@ -0,0 +1,18 @@
+package resource_test
+
+import (
+	"testing"
+
+	"github.com/nais/certificator/pkg/resource"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKindDecode(t *testing.T) {
+	var kind resource.Kind
+
+	assert.NoError(t, kind.Decode("secret"))
+	assert.Equal(t, resource.KindSecret, kind)
+	assert.NoError(t, kind.Decode("configmap"))
+	assert.Equal(t, resource.KindConfigMap, kind)
+	assert.Error(t, kind.Decode("deployment"))
+}
@@ -0,0 +1,77 @@
+package status
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Report describes the current certificate bundle, and how far it has been synchronized into namespaces.
+type Report struct {
+	Sources    []Source    `json:"sources"`
+	Bundle     *Bundle     `json:"bundle"`
+	Namespaces []Namespace `json:"namespaces"`
+}
+
+// Source describes a configured source of certificates.
+type Source struct {
+	Source string   `json:"source"`
+	Type   string   `json:"type"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+// Bundle describes the certificate bundle currently being written to namespaces.
+type Bundle struct {
+	Hash         string        `json:"hash"`
+	ChangedAt    time.Time     `json:"changedAt"`
+	Certificates []Certificate `json:"certificates"`
+}
+
+// Certificate describes a single certificate in the bundle.
+type Certificate struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Fingerprint string    `json:"fingerprint"`
+	NotAfter    time.Time `json:"notAfter"`
+	Sources     []string  `json:"sources"`
+}
+
+// Namespace describes the synchronization state of a single namespace.
+// UpToDate is true when the current bundle has been written to the namespace.
+type Namespace struct {
+	Name        string    `json:"name"`
+	UpToDate    bool      `json:"upToDate"`
+	LastSeen    time.Time `json:"lastSeen"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	LastFailure time.Time `json:"lastFailure"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// Handler serves the status report as JSON. The report is requested by sending a reply channel on the
+// requests channel, so that it can be assembled by whoever owns the state without any locking.
+// If the report isn't ready before the client gives up, nothing is written.
+func Handler(requests chan<- chan<- Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reply := make(chan Report, 1)
+		select {
+		case <-r.Context().Done():
+			return
+		case requests <- reply:
+		}
+
+		var report Report
+		select {
+		case <-r.Context().Done():
+			return
+		case report = <-reply:
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(report)
+		if err != nil {
+			log.Debugf("Write status report: %s", err)
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package status_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nais/certificator/pkg/status"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	requests := make(chan chan<- status.Report)
+	go func() {
+		reply := <-requests
+		reply <- status.Report{
+			Bundle: &status.Bundle{Hash: "abc"},
+			Namespaces: []status.Namespace{
+				{Name: "team", LastError: "forbidden"},
+			},
+		}
+	}()
+
+	recorder := httptest.NewRecorder()
+	status.Handler(requests).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", http.NoBody))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	var report status.Report
+	err := json.NewDecoder(recorder.Body).Decode(&report)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", report.Bundle.Hash)
+	assert.Len(t, report.Namespaces, 1)
+	assert.Equal(t, "forbidden", report.Namespaces[0].LastError)
+}
+
+func TestHandlerCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	requests := make(chan chan<- status.Report)
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/status", http.NoBody).WithContext(ctx)
+	status.Handler(requests).ServeHTTP(recorder, request)
+	assert.Empty(t, recorder.Body.String(), "nothing must be written when nobody answers")
+}